@@ -0,0 +1,21 @@
+/*
+Author aliases
+authors.fname/lname is the name an author wants displayed. Other names they
+have published under live here. Searchable aliases still lead to the author
+and their books, but the API never shows an alias outside the staff-only
+/authors/{id}/aliases endpoint, so former names aren't put on display.
+Set searchable to false for a name that shouldn't even be matched.
+Run after authors.sql.
+*/
+
+CREATE TABLE authorAliases(
+	aliasID 	int auto_increment not null,
+    authID 		int not null,
+    lname 		varchar(64) not null,
+    fname 		varchar(64) null,
+    kind 		enum('alternate', 'former') not null,
+    searchable 	boolean not null default true,
+    foreign key(authID) references authors(authID),
+    primary key(aliasID),
+    index authorAliases_name (lname, fname)
+) auto_increment = 1000;
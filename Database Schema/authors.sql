@@ -0,0 +1,31 @@
+/*
+Authors
+Authors are managed through the API now (GET/PATCH/DELETE /authors/{id},
+PUT /books/{id}/authors) and can exist before they're linked to a book, so
+they're no longer garbage collected when their last bookAuthor row goes.
+That trigger also pointed at a table called author, which doesn't exist, so
+any delete from bookAuthor failed while it was installed.
+Also fixes deleted_book, which compared booktags rows against bookauthor.
+A book's authors keep the order they were given in (bookAuthor.position), so the
+first author stays first in citations, MARC 100 vs 700 and OPDS/OAI creators.
+Rows that exist before this runs all get position 0 and sort by name among
+themselves.
+*/
+
+ALTER TABLE bookAuthor
+	ADD COLUMN position int not null default 0;
+
+DROP TRIGGER IF EXISTS auth_garbage_collection;
+
+#the only definition of deleted_book. tables added later clean up after a book with
+#a BEFORE DELETE trigger of their own, nobody has to copy this one
+DROP TRIGGER IF EXISTS deleted_book;
+DELIMITER //
+CREATE TRIGGER deleted_book  
+BEFORE DELETE ON books
+FOR EACH ROW
+BEGIN
+		DELETE FROM bookAuthor WHERE OLD.bookID = bookAuthor.bookID;
+		DELETE FROM booktags WHERE OLD.bookID = booktags.bookID;
+END//
+DELIMITER ;
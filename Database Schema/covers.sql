@@ -0,0 +1,23 @@
+/*
+Cover images
+Each uploaded cover is stored once as uploaded ('original') and once per
+thumbnail size the api resizes it to. books.thumbnail is no longer read or
+written by the api, drop it once nothing depends on it:
+	ALTER TABLE books DROP COLUMN thumbnail;
+Run after subjects.sql.
+*/
+
+CREATE TABLE bookCovers(
+	bookID 		int not null,
+    size 		enum('original', 'small', 'medium', 'large') not null,
+    contentType varchar(32) not null,
+    image 		mediumblob not null,
+    updatedAt 	datetime not null,
+    foreign key(bookID) references books(bookID),
+    primary key(bookID, size)
+);
+
+CREATE TRIGGER deleted_book_covers
+BEFORE DELETE ON books
+FOR EACH ROW
+	DELETE FROM bookCovers WHERE OLD.bookID = bookCovers.bookID;
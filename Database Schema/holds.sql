@@ -0,0 +1,30 @@
+/*
+Holds
+A patron gets in line for a book when every copy is out. When a copy comes
+back the oldest waiting hold becomes 'ready' and the copy is kept for that
+patron until expiresAt, after which the hold expires and the next in line
+gets it.
+Run after loans.sql.
+*/
+
+CREATE TABLE holds(
+	holdID 		int auto_increment not null,
+    bookID 		int not null,
+    caseID 		varchar(8) not null,
+    placedAt 	datetime not null,
+    status 		enum('waiting', 'ready', 'fulfilled', 'cancelled', 'expired') not null,
+    readyAt 	datetime null,
+    expiresAt 	datetime null,
+    foreign key(bookID) references books(bookID),
+    foreign key(caseID) references users(caseID),
+    primary key(holdID),
+    index holds_queue (bookID, status, holdID)
+) auto_increment = 1000;
+
+DELIMITER //
+CREATE PROCEDURE activeHolds (IN caseID VARCHAR(8))
+BEGIN
+	SELECT * FROM holds h
+		WHERE h.caseID = caseID AND h.status IN ('waiting', 'ready')
+        ORDER BY h.holdID;
+END//
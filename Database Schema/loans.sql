@@ -0,0 +1,33 @@
+/*
+Loan workflow
+Gives every loan its own id so the API can return/renew it, and records
+the return date instead of deleting the row so availability can be computed
+from loans that haven't come back yet.
+Run after bobbytables.sql.
+*/
+
+#the composite primary key was the only index on bookID, the foreign key needs one of its own
+ALTER TABLE loan ADD INDEX loan_bookID (bookID);
+ALTER TABLE loan DROP PRIMARY KEY;
+ALTER TABLE loan ADD loanID int auto_increment not null primary key FIRST;
+ALTER TABLE loan ADD returnDate date null;
+
+DROP PROCEDURE checkOutLoan;
+DELIMITER //
+CREATE PROCEDURE checkOutLoan (IN caseID VARCHAR(8), bookID INT, loanDate date, dueDate date)
+BEGIN
+	START TRANSACTION;
+	INSERT INTO loan (bookID, caseID, loanDate, dueDate, numRenewals) VALUES (bookID, caseID, loanDate, dueDate, 0);
+    UPDATE books 
+		SET loanMetrics = loanMetrics + 1 
+		WHERE books.bookID = bookID;
+	COMMIT;
+END//
+
+DELIMITER //
+CREATE PROCEDURE returnLoan (IN loanID INT)
+BEGIN
+	UPDATE loan l
+		SET l.returnDate = CURDATE()
+        WHERE l.loanID = loanID AND l.returnDate IS NULL;
+END//
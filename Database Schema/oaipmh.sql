@@ -0,0 +1,30 @@
+/*
+OAI-PMH
+Harvesters ask for records changed since a date, so books keep when they were
+added and last changed. updatedAt follows edits to the row by itself, the api
+bumps it when a book's authors, tags or subjects change. Checkouts set it to
+itself so loanMetrics doesn't count as a change. Rows that exist before this
+runs get the time it was run.
+Deleted books leave a row in deletedBooks so harvesters can drop them too.
+Datestamps are read as UTC, keep the server's time_zone at UTC.
+Run after covers.sql.
+*/
+
+ALTER TABLE books
+	ADD createdAt datetime not null default CURRENT_TIMESTAMP,
+    ADD updatedAt datetime not null default CURRENT_TIMESTAMP on update CURRENT_TIMESTAMP,
+    ADD INDEX books_updatedAt (updatedAt);
+
+CREATE TABLE deletedBooks(
+	bookID 		int not null,
+    deletedAt 	datetime not null,
+    primary key(bookID),
+    index deletedBooks_deletedAt (deletedAt)
+);
+
+#after, so a delete that fails doesn't leave a tombstone
+CREATE TRIGGER deleted_book_tombstone
+AFTER DELETE ON books
+FOR EACH ROW
+	INSERT INTO deletedBooks (bookID, deletedAt) VALUES (OLD.bookID, NOW())
+		ON DUPLICATE KEY UPDATE deletedAt = NOW();
@@ -0,0 +1,12 @@
+/*
+Patron profiles
+Optional preferred name and pronouns that patrons set themselves through
+/api/v1/users/me. Staff only see a field when the patron has turned on
+its share flag. Both flags are off by default.
+Run after bobbytables.sql.
+*/
+
+ALTER TABLE users ADD preferredName varchar(64) null;
+ALTER TABLE users ADD pronouns varchar(32) null;
+ALTER TABLE users ADD shareNameWithStaff boolean not null default false;
+ALTER TABLE users ADD sharePronounsWithStaff boolean not null default false;
@@ -0,0 +1,14 @@
+/*
+Loan history retention
+Returned loans lose their caseID once the retention period is over (the api
+does this, see CATALOG_LOAN_RETENTION_DAYS) so the row only says a copy went
+out, not who had it. loanMetrics is untouched. Patrons who want to keep their
+own history set keepLoanHistory.
+Run after holds.sql and profiles.sql.
+*/
+
+ALTER TABLE loan MODIFY caseID varchar(8) null;
+ALTER TABLE users ADD keepLoanHistory boolean not null default false;
+
+#the retention job looks for returned loans by date
+ALTER TABLE loan ADD INDEX loan_returnDate (returnDate);
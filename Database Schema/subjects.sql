@@ -0,0 +1,45 @@
+/*
+Subjects
+Controlled vocabulary subject headings (Homosaurus, https://homosaurus.org).
+Concepts are keyed by their URI and loaded with
+    go run ./backend/main.go import-homosaurus <dump.jsonld|dump.nt>
+Staff assign them to books through PUT /api/v1/books/{id}/subjects.
+Run after bobbytables.sql.
+*/
+
+CREATE TABLE subjects(
+	uri 		varchar(255) not null,
+    prefLabel 	varchar(255) not null,
+    primary key(uri),
+    index subjects_prefLabel (prefLabel)
+);
+
+CREATE TABLE subjectAltLabels(
+	uri 	varchar(255) not null,
+    label 	varchar(255) not null,
+    foreign key(uri) references subjects(uri),
+    primary key(uri, label),
+    index subjectAltLabels_label (label)
+);
+
+#one row per skos:broader/skos:narrower pair, both directions of the relation end up in the same row
+CREATE TABLE subjectRelations(
+	broader 	varchar(255) not null,
+    narrower 	varchar(255) not null,
+    foreign key(broader) references subjects(uri),
+    foreign key(narrower) references subjects(uri),
+    primary key(broader, narrower)
+);
+
+CREATE TABLE bookSubjects(
+	bookID 	int not null,
+    uri 	varchar(255) not null,
+    foreign key(bookID) references books(bookID),
+    foreign key(uri) references subjects(uri),
+    primary key(bookID, uri)
+);
+
+CREATE TRIGGER deleted_book_subjects
+BEFORE DELETE ON books
+FOR EACH ROW
+	DELETE FROM bookSubjects WHERE OLD.bookID = bookSubjects.bookID;
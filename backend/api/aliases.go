@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// authorMatchSQL selects the authIDs whose preferred name or any searchable alias matches a LIKE pattern.
+// it takes the pattern six times, use authorMatchArgs. CONCAT_WS skips a NULL fname instead of nulling the whole name.
+const authorMatchSQL = `SELECT authID FROM authors
+        WHERE fname LIKE ? OR lname LIKE ? OR CONCAT_WS(' ', fname, lname) LIKE ?
+    UNION
+    SELECT authID FROM authorAliases
+        WHERE searchable AND (fname LIKE ? OR lname LIKE ? OR CONCAT_WS(' ', fname, lname) LIKE ?)`
+
+func authorMatchArgs(pattern string) []interface{} {
+	return []interface{}{pattern, pattern, pattern, pattern, pattern, pattern}
+}
+
+func scanAlias(row interface{ Scan(...any) error }) (authorAlias, error) {
+	var a authorAlias
+	err := row.Scan(&a.ID, &a.AuthID, &a.LName, &a.FName, &a.Kind, &a.Searchable)
+	return a, err
+}
+
+// GET/POST /authors/{id}/aliases and DELETE /authors/{id}/aliases/{aliasID}, staff only.
+// this is the only place aliases are ever shown.
+func (s *Server) handleAuthorAliases(w http.ResponseWriter, r *http.Request, authID int, rawAliasID string) {
+	if _, err := s.authorByID(r.Context(), authID); errors.Is(err, errAuthorNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case rawAliasID == "" && r.Method == http.MethodGet:
+		aliases, err := s.aliasesOf(r.Context(), authID)
+		if err != nil {
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, nonNil(aliases))
+
+	case rawAliasID == "" && r.Method == http.MethodPost:
+		type payload struct {
+			LName      string  `json:"lname"`
+			FName      *string `json:"fname"`
+			Kind       string  `json:"kind"`
+			Searchable *bool   `json:"searchable"`
+		}
+		var body payload
+		if err := decodeJSON(r, &body); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(body.LName) == "" {
+			http.Error(w, "missing required fields", http.StatusBadRequest)
+			return
+		}
+		if body.Kind == "" {
+			body.Kind = "alternate"
+		}
+		if body.Kind != "alternate" && body.Kind != "former" {
+			http.Error(w, "kind must be alternate or former", http.StatusBadRequest)
+			return
+		}
+		searchable := true
+		if body.Searchable != nil {
+			searchable = *body.Searchable
+		}
+
+		res, err := s.db.ExecContext(r.Context(), `
+            INSERT INTO authorAliases (authID, lname, fname, kind, searchable)
+            VALUES (?, ?, ?, ?, ?)`,
+			authID, body.LName, body.FName, body.Kind, searchable,
+		)
+		if err != nil {
+			http.Error(w, "insert failed", http.StatusInternalServerError)
+			return
+		}
+		//searchable aliases are in the search index, this gets the author's books reindexed
+		if err := touchBooks(r.Context(), s.db, `bookID IN (SELECT bookID FROM bookAuthor WHERE authID = ?)`, authID); err != nil {
+			log.Printf("touch books of author %d: %v", authID, err)
+		}
+		id, _ := res.LastInsertId()
+		a, err := scanAlias(s.db.QueryRowContext(r.Context(), `
+            SELECT aliasID, authID, lname, fname, kind, searchable FROM authorAliases WHERE aliasID = ?`, id,
+		))
+		if err != nil {
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, a)
+
+	case rawAliasID != "" && r.Method == http.MethodDelete:
+		aliasID, err := strconv.Atoi(rawAliasID)
+		if err != nil {
+			http.Error(w, "invalid alias id", http.StatusBadRequest)
+			return
+		}
+		res, err := s.db.ExecContext(r.Context(), `
+            DELETE FROM authorAliases WHERE aliasID = ? AND authID = ?`, aliasID, authID,
+		)
+		if err != nil {
+			http.Error(w, "delete failed", http.StatusInternalServerError)
+			return
+		}
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if err := touchBooks(r.Context(), s.db, `bookID IN (SELECT bookID FROM bookAuthor WHERE authID = ?)`, authID); err != nil {
+			log.Printf("touch books of author %d: %v", authID, err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) aliasesOf(ctx context.Context, authID int) ([]authorAlias, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT aliasID, authID, lname, fname, kind, searchable FROM authorAliases
+        WHERE authID = ? ORDER BY aliasID`, authID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []authorAlias
+	for rows.Next() {
+		a, err := scanAlias(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, a)
+	}
+	return result, rows.Err()
+}
+
+// deleteAliases is part of deleting an author, aliases can't outlive the person they point at
+func deleteAliases(ctx context.Context, tx *sql.Tx, authID int) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM authorAliases WHERE authID = ?`, authID)
+	return err
+}
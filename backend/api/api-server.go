@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
@@ -17,6 +18,10 @@ import (
 
 	_ "github.com/go-sql-driver/mysql"
 	"golang.org/x/time/rate"
+	cas_auth "gopkg.in/cas.v2"
+
+	"github.com/bxb454/csds-395-lgbt-library-catalog/cas"
+	"github.com/bxb454/csds-395-lgbt-library-catalog/search"
 )
 
 //note a lot of this code is rly repetitive and could be abstracted better instead of just
@@ -25,26 +30,71 @@ import (
 // --- structs to define data types/models ---
 
 type book struct {
-	ID          int     `json:"id"`
-	ISBN        *string `json:"isbn"`
-	Title       string  `json:"title"`
-	PubDate     *string `json:"pubdate"`
-	Publisher   *string `json:"publisher"`
-	Edition     *string `json:"edition"`
-	Copies      int     `json:"copies"`
-	Thumbnail   []byte  `json:"thumbnail"`
-	LoanMetrics int     `json:"loanMetrics"`
+	ID        int     `json:"id"`
+	ISBN      *string `json:"isbn"`
+	Title     string  `json:"title"`
+	PubDate   *string `json:"pubdate"`
+	Publisher *string `json:"publisher"`
+	Edition   *string `json:"edition"`
+	Copies    int     `json:"copies"`
+	Available int     `json:"available"`
+	//null when the book has no cover, see covers.go
+	CoverURL    *string   `json:"coverUrl"`
+	LoanMetrics int       `json:"loanMetrics"`
+	CreatedAt   time.Time `json:"createdAt"`
+	//last change to the record (authors, tags and subjects included), not to its loans
+	UpdatedAt time.Time `json:"updatedAt"`
+	Authors   []author  `json:"authors"`
+	Tags      []string  `json:"tags"`
+	Subjects  []subject `json:"subjects"`
 }
 
-/*
-type author struct {
+// another name an author has published under. never part of a public response,
+// kind is alternate (pen names etc) or former
+type authorAlias struct {
+	ID         int     `json:"id"`
+	AuthID     int     `json:"authID"`
+	LName      string  `json:"lname"`
+	FName      *string `json:"fname"`
+	Kind       string  `json:"kind"`
+	Searchable bool    `json:"searchable"`
+}
 
+// a controlled vocabulary heading, uri is the Homosaurus concept
+type subject struct {
+	URI       string `json:"uri"`
+	PrefLabel string `json:"prefLabel"`
+}
+
+// fname is nullable for authors who go by one name
+type author struct {
+	AuthID int     `json:"authID"`
+	LName  string  `json:"lname"`
+	FName  *string `json:"fname"`
 }
-*/
 
-/* type loan struct {
+// caseID is a pointer since loans can outlive the link to their patron
+type loan struct {
+	ID          int        `json:"id"`
+	BookID      int        `json:"bookID"`
+	CaseID      *string    `json:"caseID"`
+	LoanDate    time.Time  `json:"loanDate"`
+	DueDate     time.Time  `json:"dueDate"`
+	ReturnDate  *time.Time `json:"returnDate"`
+	NumRenewals int        `json:"numRenewals"`
+}
 
-} */
+// status is one of waiting, ready, fulfilled, cancelled, expired
+type hold struct {
+	ID        int        `json:"id"`
+	BookID    int        `json:"bookID"`
+	CaseID    string     `json:"caseID"`
+	PlacedAt  time.Time  `json:"placedAt"`
+	Status    string     `json:"status"`
+	ReadyAt   *time.Time `json:"readyAt"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	Position  *int       `json:"position,omitempty"`
+}
 
 /*
 
@@ -61,6 +111,9 @@ type user struct {
 	CaseID       string `json:"caseID"`
 	Role         string `json:"role"`
 	IsRestricted bool   `json:"isRestricted"`
+	//only filled in when the patron shares them with staff, see profile.go
+	PreferredName *string `json:"preferredName,omitempty"`
+	Pronouns      *string `json:"pronouns,omitempty"`
 }
 
 type PaginationParams struct {
@@ -72,6 +125,16 @@ type BookFilters struct {
 	Title     string
 	ISBN      string
 	Publisher string
+	//only books with at least one copy on the shelf
+	Available bool
+	//only books linked to this author through bookAuthor, 0 means any
+	AuthorID int
+	//exact match on a normalized tag
+	Tag string
+	//only these books, nil means any
+	IDs []int
+	//ORDER BY clause, never from user input directly. bookID when empty
+	OrderBy string
 }
 
 type Server struct {
@@ -81,18 +144,42 @@ type Server struct {
 	limitMu      sync.Mutex
 	rateInterval time.Duration
 	rateBurst    int
+	cas          *cas_auth.Client
+	loanPolicy   loanPolicy
+	retention    retentionPolicy
+	//contact address OAI-PMH Identify gives harvesters
+	oaiAdminEmail string
+	//search index, built when the server starts and synced after every write (see search.go)
+	index     *search.Index
+	indexMu   sync.Mutex
+	indexedAt time.Time
+	//how often background jobs like hold expiry run
+	maintenanceInterval time.Duration
 }
 
 // --- end structs ---
 
 func parseBookFilters(r *http.Request) BookFilters {
+	available, _ := strconv.ParseBool(r.URL.Query().Get("available"))
+	//either form matches, with or without hyphens. an invalid isbn is kept as is and just matches nothing
+	isbn := r.URL.Query().Get("isbn")
+	if normalized, err := normalizeISBN(isbn); err == nil {
+		isbn = normalized
+	}
 	return BookFilters{
 		Title:     r.URL.Query().Get("title"),
-		ISBN:      r.URL.Query().Get("isbn"),
+		ISBN:      isbn,
 		Publisher: r.URL.Query().Get("publisher"),
+		Available: available,
 	}
 }
 
+// availableExpr is how many copies of a books row are on the shelf right now, i.e. copies minus
+// loans that haven't been returned minus copies kept for a ready hold. only valid where books is in scope unaliased.
+const availableExpr = `(books.copies
+    - (SELECT COUNT(*) FROM loan l WHERE l.bookID = books.bookID AND l.returnDate IS NULL)
+    - (SELECT COUNT(*) FROM holds h WHERE h.bookID = books.bookID AND h.status = 'ready'))`
+
 // simple pagination parser with defaults.
 func parsePagination(r *http.Request) PaginationParams {
 	params := PaginationParams{Limit: 10, Offset: 0}
@@ -128,6 +215,25 @@ func (bf BookFilters) buildWhereClause() (string, []interface{}) {
 		conditions = append(conditions, "publisher LIKE ?")
 		args = append(args, "%"+bf.Publisher+"%")
 	}
+	if bf.Available {
+		conditions = append(conditions, availableExpr+" > 0")
+	}
+	if bf.AuthorID != 0 {
+		conditions = append(conditions, "bookID IN (SELECT bookID FROM bookAuthor WHERE authID = ?)")
+		args = append(args, bf.AuthorID)
+	}
+	if bf.Tag != "" {
+		conditions = append(conditions, "bookID IN (SELECT bookID FROM booktags WHERE tag = ?)")
+		args = append(args, bf.Tag)
+	}
+	if bf.IDs != nil {
+		placeholders, idArgs := inClause(bf.IDs)
+		if len(bf.IDs) == 0 {
+			placeholders = "NULL"
+		}
+		conditions = append(conditions, "bookID IN ("+placeholders+")")
+		args = append(args, idArgs...)
+	}
 
 	//join conditions with " AND " and prepend "WHERE" if there are any conditions
 	whereClause := ""
@@ -150,6 +256,17 @@ func New() (*Server, error) {
 		return nil, err
 	}
 
+	//same CAS server the auth server uses unless CATALOG_CAS_URL says otherwise
+	casClient, err := cas.NewClient(os.Getenv("CATALOG_CAS_URL"))
+	if err != nil {
+		return nil, err
+	}
+
+	retention, err := retentionFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
 	//10 requests per second, max 10 burst (at once)
 	//unsuitable for non-monolithic
 	s := &Server{
@@ -158,22 +275,56 @@ func New() (*Server, error) {
 		limiters:     make(map[string]*rate.Limiter),
 		rateInterval: 100 * time.Millisecond,
 		rateBurst:    10,
+		cas:          casClient,
+		//3 weeks out, 2 more weeks per renewal, renew at most twice, a week to pick up a hold
+		loanPolicy:          loanPolicy{LoanDays: 21, RenewalDays: 14, MaxRenewals: 2, PickupDays: 7},
+		retention:           retention,
+		oaiAdminEmail:       os.Getenv("CATALOG_OAI_ADMIN_EMAIL"),
+		index:               search.New(),
+		maintenanceInterval: time.Hour,
 	}
 
+	s.routes()
+
+	return s, nil
+}
+
+// routes puts every endpoint on s.router
+func (s *Server) routes() {
 	v1 := http.NewServeMux()
 	//boris endpoints
 	v1.Handle("/books", s.wrapLimiter(s.handleBooks()))
 	//note: the trailing slash is important here to match /books/{id}
 	v1.Handle("/books/", s.wrapLimiter(s.handleBookByID()))
+	//exact paths win over the /books/ subtree
+	v1.Handle("/books/import", s.wrapLimiter(s.handleBookImport()))
+	v1.Handle("/books/export", s.wrapLimiter(s.handleBookExport()))
+	v1.Handle("/books/cite", s.wrapLimiter(s.handleBatchCite()))
 	v1.Handle("/search", s.wrapLimiter(s.handleSearch()))
+	v1.Handle("/suggest", s.wrapLimiter(s.handleSuggest()))
 	v1.Handle("/users", s.wrapLimiter(s.handleUsers()))
 	//same here
 	v1.Handle("/users/", s.wrapLimiter(s.handleUsers()))
 	//endpoints made by dan:
 	v1.Handle("/authors", s.wrapLimiter(s.handleAuthors()))
+	v1.Handle("/authors/", s.wrapLimiter(s.handleAuthorByID()))
+	v1.Handle("/tags", s.wrapLimiter(s.handleTags()))
+	v1.Handle("/tags/", s.wrapLimiter(s.handleTagByName()))
+	v1.Handle("/subjects", s.wrapLimiter(s.handleSubjects()))
+	v1.Handle("/subjects/detail", s.wrapLimiter(s.handleSubjectDetail()))
 	v1.Handle("/loans", s.wrapLimiter(s.handleLoans()))
-
-	s.router.Handle("/api/v1/", http.StripPrefix("/api/v1", v1))
+	v1.Handle("/loans/", s.wrapLimiter(s.handleLoanByID()))
+	v1.Handle("/holds/", s.wrapLimiter(s.handleHoldByID()))
+	v1.Handle("/auth/login", s.wrapLimiter(s.handleLogin()))
+	v1.Handle("/auth/logout", s.wrapLimiter(s.handleLogout()))
+	//OPDS catalog for e-reader apps, see opds.go
+	v1.Handle("/opds", s.wrapLimiter(s.handleOPDS()))
+	v1.Handle("/opds/", s.wrapLimiter(s.handleOPDS()))
+	v1.Handle("/oai", s.wrapLimiter(s.handleOAI()))
+
+	//CAS sits in front of the whole api so every handler can read the caseID off the context,
+	//then authorize checks the caller's role against the permissions table
+	s.router.Handle("/api/v1/", s.wrapCAS(http.StripPrefix("/api/v1", s.authorize(s.syncAfterWrites(v1)))))
 	s.router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		if err := s.db.PingContext(r.Context()); err != nil {
 			//throw a 503 error if the db is unavailable
@@ -182,16 +333,18 @@ func New() (*Server, error) {
 		}
 		w.Write([]byte("ok"))
 	})
-
-	return s, nil
 }
 
 func (s *Server) queryBooksWithFilters(ctx context.Context, filters BookFilters, pagination PaginationParams) ([]book, int, error) {
 	whereClause, args := filters.buildWhereClause()
+	orderBy := filters.OrderBy
+	if orderBy == "" {
+		orderBy = "bookID"
+	}
 
 	//build main query, parse pagination params, and scan
-	query := `SELECT bookID, isbn, title, pubdate, publisher, edition, copies, thumbnail, loanMetrics FROM books` +
-		whereClause + ` ORDER BY bookID LIMIT ? OFFSET ?`
+	query := `SELECT bookID, isbn, title, pubdate, publisher, edition, copies, ` + availableExpr + `, ` + coverVersionExpr + `, loanMetrics, createdAt, updatedAt FROM books` +
+		whereClause + ` ORDER BY ` + orderBy + ` LIMIT ? OFFSET ?`
 	//we can use OFFSET keyword in SQL to skip a number of rows for offset pagination method
 	args = append(args, pagination.Limit, pagination.Offset)
 
@@ -204,15 +357,40 @@ func (s *Server) queryBooksWithFilters(ctx context.Context, filters BookFilters,
 	var result []book
 	for rows.Next() {
 		var b book
+		var coverVersion sql.NullInt64
 		if err := rows.Scan(
 			&b.ID, &b.ISBN, &b.Title, &b.PubDate,
-			&b.Publisher, &b.Edition, &b.Copies, &b.Thumbnail, &b.LoanMetrics,
+			&b.Publisher, &b.Edition, &b.Copies, &b.Available, &coverVersion, &b.LoanMetrics, &b.CreatedAt, &b.UpdatedAt,
 		); err != nil {
 			return nil, 0, err
 		}
+		b.CoverURL = coverURL(b.ID, coverVersion)
 		result = append(result, b)
 	}
 
+	//attach authors, tags and subjects with one extra query each for the whole page instead of one per book
+	ids := make([]int, len(result))
+	for i, b := range result {
+		ids[i] = b.ID
+	}
+	authors, err := s.authorsForBooks(ctx, ids)
+	if err != nil {
+		return nil, 0, err
+	}
+	tags, err := s.tagsForBooks(ctx, ids)
+	if err != nil {
+		return nil, 0, err
+	}
+	subjects, err := s.subjectsForBooks(ctx, ids)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range result {
+		result[i].Authors = nonNil(authors[result[i].ID])
+		result[i].Tags = nonNil(tags[result[i].ID])
+		result[i].Subjects = nonNil(subjects[result[i].ID])
+	}
+
 	//get the total count of books
 	countQuery := `SELECT COUNT(*) FROM books` + whereClause
 	//countArgs, _ := filters.buildWhereClause()
@@ -228,6 +406,14 @@ func (s *Server) queryBooksWithFilters(ctx context.Context, filters BookFilters,
 
 func (s *Server) Serve(addr string) error {
 	defer s.db.Close()
+	if err := s.rebuildIndex(context.Background()); err != nil {
+		return fmt.Errorf("building search index: %w", err)
+	}
+	log.Printf("search index has %d books", s.index.Len())
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.runMaintenance(stop)
+
 	log.Printf("API server listening on %s", addr)
 	return http.ListenAndServe(addr, s.router)
 }
@@ -240,6 +426,21 @@ func (s *Server) handleBooks() http.Handler {
 		case http.MethodGet:
 			pagination := parsePagination(r)
 			filters := parseBookFilters(r)
+			if isbn := r.URL.Query().Get("isbn"); isbn != "" {
+				if _, err := normalizeISBN(isbn); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			//?sort=-popularity,title, see bookSortColumns
+			keys, err := parseSort(r.URL.Query().Get("sort"), sortNames(bookSortColumns))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(keys) > 0 {
+				filters.OrderBy = orderBySQL(keys, bookSortColumns, "bookID")
+			}
 
 			books, total, err := s.queryBooksWithFilters(r.Context(), filters, pagination)
 			if err != nil {
@@ -247,17 +448,7 @@ func (s *Server) handleBooks() http.Handler {
 				return
 			}
 
-			response := map[string]interface{}{
-				"data": books,
-				"pagination": map[string]interface{}{
-					"limit":   pagination.Limit,
-					"offset":  pagination.Offset,
-					"total":   total,
-					"hasMore": pagination.Offset+pagination.Limit < total,
-				},
-			}
-
-			writeJSON(w, http.StatusOK, response)
+			writeJSON(w, http.StatusOK, paginatedResponse(books, pagination, total))
 
 		case http.MethodPost:
 			type payload struct {
@@ -278,12 +469,24 @@ func (s *Server) handleBooks() http.Handler {
 				http.Error(w, "missing required fields", http.StatusBadRequest)
 				return
 			}
+			//stored as a bare ISBN-13 no matter how it was typed in
+			if body.ISBN != nil && strings.TrimSpace(*body.ISBN) == "" {
+				body.ISBN = nil
+			}
+			if body.ISBN != nil {
+				isbn, err := normalizeISBN(*body.ISBN)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				body.ISBN = &isbn
+			}
 
 			//loan metrics will be added by 1 every time it's checked out
 
 			res, err := s.db.ExecContext(r.Context(), `
-                INSERT INTO books (isbn, title, pubdate, publisher, edition, copies, thumbnail, loanMetrics)
-                VALUES (?, ?, ?, ?, ?, ?, ?, 0)`,
+                INSERT INTO books (isbn, title, pubdate, publisher, edition, copies, loanMetrics)
+                VALUES (?, ?, ?, ?, ?, ?, 0)`,
 				body.ISBN, body.Title, body.PubDate, body.Publisher, body.Edition, body.Copies,
 			)
 			if err != nil {
@@ -303,28 +506,48 @@ func (s *Server) handleBooks() http.Handler {
 // no need for pagination since it's just one item
 func (s *Server) handleBookByID() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		id := strings.TrimPrefix(r.URL.Path, "/books/")
+		id, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/books/"), "/")
 		if id == "" {
 			http.Error(w, "missing id", http.StatusBadRequest)
 			return
 		}
+		//sub resources like /books/{id}/holds get their own handlers
+		switch {
+		case sub == "":
+		case sub == "holds":
+			s.handleBookHolds(w, r, id)
+			return
+		case sub == "authors":
+			s.handleBookAuthors(w, r, id)
+			return
+		case sub == "subjects":
+			s.handleBookSubjects(w, r, id)
+			return
+		case sub == "cover":
+			s.handleBookCover(w, r, id)
+			return
+		case sub == "marc":
+			s.handleBookMARC(w, r, id)
+			return
+		case sub == "cite":
+			s.handleBookCite(w, r, id)
+			return
+		case sub == "tags" || strings.HasPrefix(sub, "tags/"):
+			s.handleBookTags(w, r, id, strings.TrimPrefix(strings.TrimPrefix(sub, "tags"), "/"))
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		bookID, err := strconv.Atoi(id)
+		if err != nil {
+			http.Error(w, "invalid book id", http.StatusBadRequest)
+			return
+		}
 		switch r.Method {
 		case http.MethodGet:
-			var (
-				bookID      int
-				isbn        sql.NullString
-				title       string
-				pubdate     sql.NullString
-				publisher   sql.NullString
-				edition     sql.NullString
-				copies      int
-				loanMetrics int
-			)
-			err := s.db.QueryRowContext(r.Context(), `
-                SELECT bookID, isbn, title, pubdate, publisher, edition, copies, loanMetrics
-                FROM books WHERE bookID = ?`, id,
-			).Scan(&bookID, &isbn, &title, &pubdate, &publisher, &edition, &copies, &loanMetrics)
-			if errors.Is(err, sql.ErrNoRows) {
+			book, err := s.bookDetail(r.Context(), bookID)
+			if errors.Is(err, errBookNotFound) {
 				http.NotFound(w, r)
 				return
 			}
@@ -333,25 +556,52 @@ func (s *Server) handleBookByID() http.Handler {
 				http.Error(w, "query failed", http.StatusInternalServerError)
 				return
 			}
-			writeJSON(w, http.StatusOK, map[string]any{
-				"id":          bookID,
-				"isbn":        nullString(isbn),
-				"title":       title,
-				"pubdate":     nullString(pubdate),
-				"publisher":   nullString(publisher),
-				"edition":     nullString(edition),
-				"copies":      copies,
-				"loanMetrics": loanMetrics,
-			})
+			writeJSON(w, http.StatusOK, book)
 
-		case http.MethodDelete:
-			res, err := s.db.ExecContext(r.Context(), `DELETE FROM books WHERE bookID = ?`, id)
+		//merge semantics: only the fields you send change, null clears one
+		//EXAMPLE: PATCH /books/1001 {"title": "Stone Butch Blues", "edition": null, "copies": 3}
+		case http.MethodPatch:
+			var body map[string]json.RawMessage
+			if err := decodeJSON(r, &body); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+			sets, args, copies, err := parseBookPatch(body)
 			if err != nil {
-				http.Error(w, "delete failed", http.StatusInternalServerError)
+				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-			if rows, _ := res.RowsAffected(); rows == 0 {
-				http.NotFound(w, r)
+			if err := s.updateBook(r.Context(), bookID, sets, args, copies); err != nil {
+				switch {
+				case errors.Is(err, errBookNotFound):
+					http.NotFound(w, r)
+				case errors.Is(err, errCopiesInUse):
+					http.Error(w, err.Error(), http.StatusConflict)
+				default:
+					log.Printf("update book: %v", err)
+					http.Error(w, "update failed", http.StatusInternalServerError)
+				}
+				return
+			}
+			book, err := s.bookDetail(r.Context(), bookID)
+			if err != nil {
+				log.Printf("query error: %v", err)
+				http.Error(w, "query failed", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, book)
+
+		case http.MethodDelete:
+			if err := s.deleteBook(r.Context(), bookID); err != nil {
+				switch {
+				case errors.Is(err, errBookNotFound):
+					http.NotFound(w, r)
+				case errors.Is(err, errBookInUse):
+					http.Error(w, err.Error(), http.StatusConflict)
+				default:
+					log.Printf("delete book: %v", err)
+					http.Error(w, "delete failed", http.StatusInternalServerError)
+				}
 				return
 			}
 			w.WriteHeader(http.StatusNoContent)
@@ -365,11 +615,28 @@ func (s *Server) handleBookByID() http.Handler {
 func (s *Server) handleUsers() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Extract caseID from path if present
-		caseID := strings.TrimPrefix(r.URL.Path, "/users/")
+		caseID, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/users/"), "/")
 
 		// If there's a caseID, handle single user operations
+		// "me" is the caller, /users/me itself is the self-service profile
+		if caseID == "me" {
+			if sub == "" {
+				s.handleMe(w, r)
+				return
+			}
+			caseID = callerFrom(r.Context()).CaseID
+		}
 		if caseID != "" {
-			s.handleSingleUser(w, r, caseID)
+			switch sub {
+			case "":
+				s.handleSingleUser(w, r, caseID)
+			case "holds":
+				s.handleUserHolds(w, r, caseID)
+			case "loan-history":
+				s.handleLoanHistory(w, r, caseID)
+			default:
+				http.NotFound(w, r)
+			}
 			return
 		}
 
@@ -379,7 +646,7 @@ func (s *Server) handleUsers() http.Handler {
 			// List all users (with pagination)
 			pagination := parsePagination(r)
 			rows, err := s.db.QueryContext(r.Context(), `
-                SELECT caseID, role, isRestricted FROM users
+                SELECT `+userColumns+` FROM users
                 ORDER BY caseID LIMIT ? OFFSET ?`,
 				pagination.Limit, pagination.Offset,
 			)
@@ -391,8 +658,8 @@ func (s *Server) handleUsers() http.Handler {
 
 			var users []user
 			for rows.Next() {
-				var u user
-				if err := rows.Scan(&u.CaseID, &u.Role, &u.IsRestricted); err != nil {
+				u, err := scanUser(rows)
+				if err != nil {
 					http.Error(w, "scan failed", http.StatusInternalServerError)
 					return
 				}
@@ -402,16 +669,7 @@ func (s *Server) handleUsers() http.Handler {
 			var total int
 			s.db.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM users`).Scan(&total)
 
-			response := map[string]interface{}{
-				"data": users,
-				"pagination": map[string]interface{}{
-					"limit":   pagination.Limit,
-					"offset":  pagination.Offset,
-					"total":   total,
-					"hasMore": pagination.Offset+pagination.Limit < total,
-				},
-			}
-			writeJSON(w, http.StatusOK, response)
+			writeJSON(w, http.StatusOK, paginatedResponse(users, pagination, total))
 
 		case http.MethodPost:
 			var u user
@@ -423,6 +681,10 @@ func (s *Server) handleUsers() http.Handler {
 				http.Error(w, "missing required fields", http.StatusBadRequest)
 				return
 			}
+			if _, ok := parseRole(u.Role); !ok {
+				http.Error(w, "invalid role", http.StatusBadRequest)
+				return
+			}
 
 			_, err := s.db.ExecContext(r.Context(), `
                 INSERT INTO users (caseID, role, isRestricted)
@@ -442,13 +704,18 @@ func (s *Server) handleUsers() http.Handler {
 }
 
 func (s *Server) handleSingleUser(w http.ResponseWriter, r *http.Request, caseID string) {
+	c := callerFrom(r.Context())
 	switch r.Method {
 	case http.MethodGet:
-		var u user
-		err := s.db.QueryRowContext(r.Context(), `
-            SELECT caseID, role, isRestricted FROM users WHERE caseID = ?`,
+		//patrons can look themselves up but nobody else
+		if !c.canActFor(caseID) {
+			writeError(w, http.StatusForbidden, "cannot view another user")
+			return
+		}
+		u, err := scanUser(s.db.QueryRowContext(r.Context(), `
+            SELECT `+userColumns+` FROM users WHERE caseID = ?`,
 			caseID,
-		).Scan(&u.CaseID, &u.Role, &u.IsRestricted)
+		))
 		if errors.Is(err, sql.ErrNoRows) {
 			http.NotFound(w, r)
 			return
@@ -460,19 +727,58 @@ func (s *Server) handleSingleUser(w http.ResponseWriter, r *http.Request, caseID
 		writeJSON(w, http.StatusOK, u)
 
 	case http.MethodPatch:
-		var updates user
+		//pointers so we can tell "not sent" apart from false/empty
+		type payload struct {
+			Role         *string `json:"role"`
+			IsRestricted *bool   `json:"isRestricted"`
+		}
+		var updates payload
 		if err := decodeJSON(r, &updates); err != nil {
 			http.Error(w, "invalid json", http.StatusBadRequest)
 			return
 		}
-		_, err := s.db.ExecContext(r.Context(), `
-            UPDATE users SET role = ?, isRestricted = ? WHERE caseID = ?`,
-			updates.Role, updates.IsRestricted, caseID,
+
+		var sets []string
+		var args []interface{}
+		if updates.Role != nil {
+			if _, ok := parseRole(*updates.Role); !ok {
+				http.Error(w, "invalid role", http.StatusBadRequest)
+				return
+			}
+			//staff can restrict patrons but only admins hand out roles
+			if c.Role < roleAdmin {
+				writeError(w, http.StatusForbidden, "admin role required to change roles")
+				return
+			}
+			sets = append(sets, "role = ?")
+			args = append(args, *updates.Role)
+		}
+		if updates.IsRestricted != nil {
+			sets = append(sets, "isRestricted = ?")
+			args = append(args, *updates.IsRestricted)
+		}
+		if len(sets) == 0 {
+			http.Error(w, "nothing to update", http.StatusBadRequest)
+			return
+		}
+
+		args = append(args, caseID)
+		res, err := s.db.ExecContext(r.Context(),
+			`UPDATE users SET `+strings.Join(sets, ", ")+` WHERE caseID = ?`, args...,
 		)
 		if err != nil {
 			http.Error(w, "update failed", http.StatusInternalServerError)
 			return
 		}
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			//could also mean nothing changed, only 404 if they really don't exist
+			var exists bool
+			s.db.QueryRowContext(r.Context(), `SELECT EXISTS(SELECT 1 FROM users WHERE caseID = ?)`, caseID).Scan(&exists)
+			if !exists {
+				http.NotFound(w, r)
+				return
+			}
+		}
 		w.WriteHeader(http.StatusNoContent)
 
 	case http.MethodDelete:
@@ -492,180 +798,31 @@ func (s *Server) handleSingleUser(w http.ResponseWriter, r *http.Request, caseID
 	}
 }
 
-// handle search across books, authors, tags
-// note: we should get authors and tags endpoints working. this works without them but we need them
-// EXAMPLE: GET/api/v1/search?q=Stone&limit=5&offset=10
-func (s *Server) handleSearch() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		query := r.URL.Query().Get("q")
-		if query == "" {
-			http.Error(w, "missing search query", http.StatusBadRequest)
-			return
-		}
-
-		pagination := parsePagination(r)
-
-		//get total count of results
-		//this might be awful for performance but it works for now
-		var total int
-		err := s.db.QueryRowContext(r.Context(), `
-            SELECT COUNT(*) FROM (
-                SELECT bookID FROM books WHERE title LIKE ?
-                UNION
-                SELECT authID FROM authors WHERE fname LIKE ? OR lname LIKE ?
-                UNION
-                SELECT NULL FROM booktags WHERE tag LIKE ?
-            ) AS totalResults`,
-			"%"+query+"%", "%"+query+"%", "%"+query+"%", "%"+query+"%",
-		).Scan(&total)
-		if err != nil {
-			http.Error(w, "failed to count search results", http.StatusInternalServerError)
-			return
-		}
-
-		//get paginated results
-		rows, err := s.db.QueryContext(r.Context(), `
-            SELECT 'book' AS type, bookID AS id, title AS name FROM books WHERE title LIKE ?
-            UNION
-            SELECT 'author', authID, CONCAT(fname, ' ', lname) FROM authors WHERE fname LIKE ? OR lname LIKE ?
-            UNION
-            SELECT 'tag', NULL, tag FROM booktags WHERE tag LIKE ?
-            LIMIT ? OFFSET ?`,
-			"%"+query+"%", "%"+query+"%", "%"+query+"%", "%"+query+"%",
-			pagination.Limit, pagination.Offset,
-		)
-		if err != nil {
-			http.Error(w, "search query failed", http.StatusInternalServerError)
-			return
-		}
-		defer rows.Close()
-
-		var results []map[string]interface{}
-		for rows.Next() {
-			var resultType string
-			var id sql.NullInt64
-			var name string
-			if err := rows.Scan(&resultType, &id, &name); err != nil {
-				http.Error(w, "scan failed", http.StatusInternalServerError)
-				return
-			}
-			results = append(results, map[string]interface{}{
-				"type": resultType,
-				"id":   id.Int64,
-				"name": name,
-			})
-		}
-
-		//build the response with the metadata for pagination
-		response := map[string]interface{}{
-			"data": results,
-			"pagination": map[string]interface{}{
-				"limit":   pagination.Limit,
-				"offset":  pagination.Offset,
-				"total":   total,
-				"hasMore": pagination.Offset+pagination.Limit < total,
-			},
-		}
-
-		writeJSON(w, http.StatusOK, response)
-	})
-}
-
-// dan wrote this
-func (s *Server) handleAuthors() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			rows, error := s.db.QueryContext(r.Context(), `
-			SELECT authID, lname, fname FROM authors`)
-			if error != nil {
-				http.Error(w, "query failed", http.StatusInternalServerError)
-				return
-			}
-			defer rows.Close()
-
-			type authors struct {
-				AuthID int     `json:"authID"`
-				LName  *string `json:"lname"`
-				FName  *string `json:"fname"`
-			}
-
-			var result []authors
-
-			for rows.Next() {
-				var a authors
-				if error := rows.Scan(
-					&a.AuthID, &a.LName, &a.FName,
-				); error != nil {
-					http.Error(w, "Scan failed", http.StatusInternalServerError)
-					return
-				}
-				result = append(result, a)
-			}
-			writeJSON(w, http.StatusOK, result)
-
-		case http.MethodPost:
-			type payload struct {
-				AuthID int     `json:"authID"`
-				LName  *string `json:"lname"`
-				FName  *string `json:"fname"`
-			}
-			var body payload
-			if err := decodeJSON(r, &body); err != nil {
-				http.Error(w, "invalid json", http.StatusBadRequest)
-				return
-			}
-			if body.AuthID == 0 || *body.LName == "" || *body.FName == "" {
-				http.Error(w, "missing required fields", http.StatusBadRequest)
-				return
-			}
-
-			res, err := s.db.ExecContext(r.Context(), `
-                INSERT INTO loan (authID, lname, fname)
-                VALUES (?, ?, 0)`,
-				body.AuthID, body.LName, body.FName,
-			)
-			if err != nil {
-				http.Error(w, "insert failed", http.StatusInternalServerError)
-				return
-			}
-			id, _ := res.LastInsertId()
-			writeJSON(w, http.StatusCreated, map[string]any{"id": id})
-
-		default:
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-}
-
 // dan also wrote this
 func (s *Server) handleLoans() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := callerFrom(r.Context())
 		switch r.Method {
 		case http.MethodGet:
-			rows, error := s.db.QueryContext(r.Context(), `
-			SELECT bookID, caseID, loanDate, dueDate, numRenewals FROM loan`)
+			query := `SELECT ` + loanColumns + ` FROM loan`
+			var args []interface{}
+			//patrons only get to see their own loans
+			if c.Role < roleStaff {
+				query += ` WHERE caseID = ?`
+				args = append(args, c.CaseID)
+			}
+			rows, error := s.db.QueryContext(r.Context(), query+` ORDER BY loanID`, args...)
 			if error != nil {
 				http.Error(w, "query failed", http.StatusInternalServerError)
 				return
 			}
 			defer rows.Close()
 
-			type loan struct {
-				BookID      int       `json:"bookID"`
-				CaseID      *string   `json:"caseID"`
-				LoanDate    time.Time `json:"loanDate"`
-				DueDate     time.Time `json:"dueDate"`
-				NumRenewals int       `json:"numRenewals"`
-			}
-
 			var result []loan
 
 			for rows.Next() {
-				var l loan
-				if error := rows.Scan(
-					&l.BookID, &l.CaseID, &l.LoanDate, &l.DueDate, &l.NumRenewals,
-				); error != nil {
+				l, error := scanLoan(rows)
+				if error != nil {
 					http.Error(w, "Scan failed", http.StatusInternalServerError)
 					return
 				}
@@ -674,34 +831,8 @@ func (s *Server) handleLoans() http.Handler {
 			writeJSON(w, http.StatusOK, result)
 
 		case http.MethodPost:
-			type payload struct {
-				BookID      int       `json:"bookID"`
-				CaseID      *string   `json:"caseID"`
-				LoanDate    time.Time `json:"loanDate"`
-				DueDate     time.Time `json:"dueDate"`
-				NumRenewals int       `json:"numRenewals"`
-			}
-			var body payload
-			if err := decodeJSON(r, &body); err != nil {
-				http.Error(w, "invalid json", http.StatusBadRequest)
-				return
-			}
-			if body.BookID <= 0 || *body.CaseID == "" || body.NumRenewals < 0 {
-				http.Error(w, "missing required fields", http.StatusBadRequest)
-				return
-			}
-
-			res, err := s.db.ExecContext(r.Context(), `
-                INSERT INTO loan (bookID, caseID, loanDate, dueDate, numRenewals)
-                VALUES (?, ?, ?, ?, 0)`,
-				body.BookID, body.CaseID, body.LoanDate, body.DueDate, body.NumRenewals,
-			)
-			if err != nil {
-				http.Error(w, "insert failed", http.StatusInternalServerError)
-				return
-			}
-			id, _ := res.LastInsertId()
-			writeJSON(w, http.StatusCreated, map[string]any{"id": id})
+			//same as POST /loans/checkout, due dates come from the loan policy now
+			s.checkOut(w, r)
 
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -749,11 +880,46 @@ func writeJSON(w http.ResponseWriter, status int, data any) {
 	_ = json.NewEncoder(w).Encode(data)
 }
 
+// writeError sends {"error": msg} so clients get the same shape for every auth failure
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
 func decodeJSON(r *http.Request, out any) error {
 	defer r.Body.Close()
 	return json.NewDecoder(r.Body).Decode(out)
 }
 
+// paginatedResponse is the {"data": ..., "pagination": ...} envelope every list endpoint returns
+func paginatedResponse(data any, pagination PaginationParams, total int) map[string]interface{} {
+	return map[string]interface{}{
+		"data": data,
+		"pagination": map[string]interface{}{
+			"limit":   pagination.Limit,
+			"offset":  pagination.Offset,
+			"total":   total,
+			"hasMore": pagination.Offset+pagination.Limit < total,
+		},
+	}
+}
+
+// inClause builds the "?, ?, ?" for an IN (...) along with its args
+func inClause[T any](values []T) (string, []interface{}) {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", "), args
+}
+
+// nonNil keeps empty lists as [] in the json instead of null
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
 func nullString(ns sql.NullString) *string {
 	if ns.Valid {
 		return &ns.String
@@ -0,0 +1,402 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errAuthorNotFound = errors.New("author not found")
+
+func scanAuthor(row interface{ Scan(...any) error }) (author, error) {
+	var a author
+	err := row.Scan(&a.AuthID, &a.LName, &a.FName)
+	return a, err
+}
+
+func (s *Server) authorByID(ctx context.Context, id int) (author, error) {
+	a, err := scanAuthor(s.db.QueryRowContext(ctx, `SELECT authID, lname, fname FROM authors WHERE authID = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return author{}, errAuthorNotFound
+	}
+	return a, err
+}
+
+// dan wrote this, now paginated and searchable with ?q=
+// EXAMPLE: GET /api/v1/authors?q=lorde&limit=10&offset=0
+// EXAMPLE: GET /api/v1/authors?sort=-books,name (see authorSortColumns)
+func (s *Server) handleAuthors() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			pagination := parsePagination(r)
+			keys, err := parseSort(r.URL.Query().Get("sort"), sortNames(authorSortColumns))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			orderBy := "lname, fname, authID"
+			if len(keys) > 0 {
+				orderBy = orderBySQL(keys, authorSortColumns, "authID")
+			}
+
+			whereClause := ""
+			var args []interface{}
+			//matches searchable aliases too, the response only ever has the preferred name
+			if q := r.URL.Query().Get("q"); q != "" {
+				whereClause = ` WHERE authID IN (` + authorMatchSQL + `)`
+				args = authorMatchArgs("%" + q + "%")
+			}
+
+			var total int
+			if err := s.db.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM authors`+whereClause, args...).Scan(&total); err != nil {
+				http.Error(w, "query failed", http.StatusInternalServerError)
+				return
+			}
+
+			rows, err := s.db.QueryContext(r.Context(), `
+                SELECT authID, lname, fname FROM authors`+whereClause+`
+                ORDER BY `+orderBy+` LIMIT ? OFFSET ?`,
+				append(args, pagination.Limit, pagination.Offset)...,
+			)
+			if err != nil {
+				http.Error(w, "query failed", http.StatusInternalServerError)
+				return
+			}
+			defer rows.Close()
+
+			var result []author
+			for rows.Next() {
+				a, err := scanAuthor(rows)
+				if err != nil {
+					http.Error(w, "Scan failed", http.StatusInternalServerError)
+					return
+				}
+				result = append(result, a)
+			}
+			writeJSON(w, http.StatusOK, paginatedResponse(result, pagination, total))
+
+		case http.MethodPost:
+			type payload struct {
+				LName string  `json:"lname"`
+				FName *string `json:"fname"`
+			}
+			var body payload
+			if err := decodeJSON(r, &body); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+			//fname is nullable for single-name authors, lname isn't
+			if strings.TrimSpace(body.LName) == "" {
+				http.Error(w, "missing required fields", http.StatusBadRequest)
+				return
+			}
+
+			res, err := s.db.ExecContext(r.Context(), `
+                INSERT INTO authors (lname, fname) VALUES (?, ?)`,
+				body.LName, body.FName,
+			)
+			if err != nil {
+				http.Error(w, "insert failed", http.StatusInternalServerError)
+				return
+			}
+			id, _ := res.LastInsertId()
+			writeJSON(w, http.StatusCreated, map[string]any{"id": id})
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// GET/PATCH/DELETE /authors/{id}, GET /authors/{id}/books and /authors/{id}/aliases
+func (s *Server) handleAuthorByID() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idStr, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/authors/"), "/")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "invalid author id", http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case sub == "":
+		case sub == "books":
+			s.handleAuthorBooks(w, r, id)
+			return
+		case sub == "aliases" || strings.HasPrefix(sub, "aliases/"):
+			s.handleAuthorAliases(w, r, id, strings.TrimPrefix(strings.TrimPrefix(sub, "aliases"), "/"))
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			a, err := s.authorByID(r.Context(), id)
+			if errors.Is(err, errAuthorNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			if err != nil {
+				http.Error(w, "query failed", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, a)
+
+		case http.MethodPatch:
+			//raw fields so an explicit null fname can clear it while a missing one leaves it alone
+			var body map[string]json.RawMessage
+			if err := decodeJSON(r, &body); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+
+			var sets []string
+			var args []interface{}
+			if raw, ok := body["lname"]; ok {
+				var lname string
+				if err := json.Unmarshal(raw, &lname); err != nil || strings.TrimSpace(lname) == "" {
+					http.Error(w, "lname must be a non-empty string", http.StatusBadRequest)
+					return
+				}
+				sets = append(sets, "lname = ?")
+				args = append(args, lname)
+			}
+			if raw, ok := body["fname"]; ok {
+				var fname *string
+				if err := json.Unmarshal(raw, &fname); err != nil {
+					http.Error(w, "fname must be a string or null", http.StatusBadRequest)
+					return
+				}
+				sets = append(sets, "fname = ?")
+				args = append(args, fname)
+			}
+			if len(sets) == 0 {
+				http.Error(w, "nothing to update", http.StatusBadRequest)
+				return
+			}
+
+			if _, err := s.authorByID(r.Context(), id); errors.Is(err, errAuthorNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			args = append(args, id)
+			if _, err := s.db.ExecContext(r.Context(),
+				`UPDATE authors SET `+strings.Join(sets, ", ")+` WHERE authID = ?`, args...,
+			); err != nil {
+				http.Error(w, "update failed", http.StatusInternalServerError)
+				return
+			}
+			//a rename changes the creator of every one of their books
+			if err := touchBooks(r.Context(), s.db, `bookID IN (SELECT bookID FROM bookAuthor WHERE authID = ?)`, id); err != nil {
+				log.Printf("touch books of author %d: %v", id, err)
+			}
+
+			a, err := s.authorByID(r.Context(), id)
+			if err != nil {
+				http.Error(w, "query failed", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, a)
+
+		case http.MethodDelete:
+			if err := s.deleteAuthor(r.Context(), id); err != nil {
+				if errors.Is(err, errAuthorNotFound) {
+					http.NotFound(w, r)
+					return
+				}
+				log.Printf("delete author: %v", err)
+				http.Error(w, "delete failed", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// unlinks the author from all their books and drops their aliases first, the foreign keys won't let the row go otherwise
+func (s *Server) deleteAuthor(ctx context.Context, id int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := touchBooks(ctx, tx, `bookID IN (SELECT bookID FROM bookAuthor WHERE authID = ?)`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM bookAuthor WHERE authID = ?`, id); err != nil {
+		return err
+	}
+	if err := deleteAliases(ctx, tx, id); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM authors WHERE authID = ?`, id)
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return errAuthorNotFound
+	}
+	return tx.Commit()
+}
+
+// GET /authors/{id}/books, same shape and filters as GET /books
+func (s *Server) handleAuthorBooks(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := s.authorByID(r.Context(), id); errors.Is(err, errAuthorNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+
+	pagination := parsePagination(r)
+	filters := parseBookFilters(r)
+	filters.AuthorID = id
+
+	books, total, err := s.queryBooksWithFilters(r.Context(), filters, pagination)
+	if err != nil {
+		http.Error(w, "query failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, paginatedResponse(books, pagination, total))
+}
+
+// GET /books/{id}/authors, and PUT to replace the whole list: {"authorIDs": [1000, 1001]}
+func (s *Server) handleBookAuthors(w http.ResponseWriter, r *http.Request, id string) {
+	bookID, err := strconv.Atoi(id)
+	if err != nil {
+		http.Error(w, "invalid book id", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		type payload struct {
+			AuthorIDs []int `json:"authorIDs"`
+		}
+		var body payload
+		if err := decodeJSON(r, &body); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := s.setBookAuthors(r.Context(), bookID, body.AuthorIDs); err != nil {
+			switch {
+			case errors.Is(err, errBookNotFound):
+				http.NotFound(w, r)
+			case errors.Is(err, errAuthorNotFound):
+				http.Error(w, "unknown author id", http.StatusBadRequest)
+			default:
+				log.Printf("set book authors: %v", err)
+				http.Error(w, "update failed", http.StatusInternalServerError)
+			}
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var exists bool
+	s.db.QueryRowContext(r.Context(), `SELECT EXISTS(SELECT 1 FROM books WHERE bookID = ?)`, bookID).Scan(&exists)
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	authors, err := s.authorsForBooks(r.Context(), []int{bookID})
+	if err != nil {
+		http.Error(w, "query failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(authors[bookID]))
+}
+
+// setBookAuthors swaps the book's bookAuthor rows for exactly authorIDs in one transaction.
+// their order is kept in bookAuthor.position, the first one is the main author
+func (s *Server) setBookAuthors(ctx context.Context, bookID int, authorIDs []int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked int
+	err = tx.QueryRowContext(ctx, `SELECT bookID FROM books WHERE bookID = ? FOR UPDATE`, bookID).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errBookNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM bookAuthor WHERE bookID = ?`, bookID); err != nil {
+		return err
+	}
+	seen := make(map[int]bool)
+	for _, authID := range authorIDs {
+		if seen[authID] {
+			continue
+		}
+		position := len(seen)
+		seen[authID] = true
+
+		var found bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM authors WHERE authID = ?)`, authID).Scan(&found); err != nil {
+			return err
+		}
+		if !found {
+			return errAuthorNotFound
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO bookAuthor (bookID, authID, position) VALUES (?, ?, ?)`, bookID, authID, position); err != nil {
+			return err
+		}
+	}
+	if err := touchBooks(ctx, tx, `bookID = ?`, bookID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// authorsForBooks loads the authors of a page of books in one query, keyed by bookID, in the order
+// they were set. rows that were there when authors.sql ran all have position 0 and go by name
+func (s *Server) authorsForBooks(ctx context.Context, bookIDs []int) (map[int][]author, error) {
+	result := make(map[int][]author)
+	if len(bookIDs) == 0 {
+		return result, nil
+	}
+
+	placeholders, args := inClause(bookIDs)
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT ba.bookID, a.authID, a.lname, a.fname
+        FROM bookAuthor ba JOIN authors a ON a.authID = ba.authID
+        WHERE ba.bookID IN (`+placeholders+`)
+        ORDER BY ba.position, a.lname, a.fname, a.authID`, args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID int
+		var a author
+		if err := rows.Scan(&bookID, &a.AuthID, &a.LName, &a.FName); err != nil {
+			return nil, err
+		}
+		result[bookID] = append(result[bookID], a)
+	}
+	return result, rows.Err()
+}
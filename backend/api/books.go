@@ -0,0 +1,271 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	errInvalidBook = errors.New("invalid book")
+	//copies can't go below what's physically out or waiting on the hold shelf
+	errCopiesInUse = errors.New("copies in use")
+	//loans and holds keep pointing at their book, so one with any can't be deleted
+	errBookInUse = errors.New("book can't be deleted")
+)
+
+// bookDetail is the single book view GET /books/{id} returns, authors, tags and subjects included
+func (s *Server) bookDetail(ctx context.Context, bookID int) (map[string]any, error) {
+	var (
+		isbn         sql.NullString
+		title        string
+		pubdate      sql.NullString
+		publisher    sql.NullString
+		edition      sql.NullString
+		copies       int
+		available    int
+		loanMetrics  int
+		coverVersion sql.NullInt64
+		createdAt    time.Time
+		updatedAt    time.Time
+	)
+	err := s.db.QueryRowContext(ctx, `
+        SELECT isbn, title, pubdate, publisher, edition, copies, `+availableExpr+`, loanMetrics, `+coverVersionExpr+`, createdAt, updatedAt
+        FROM books WHERE bookID = ?`, bookID,
+	).Scan(&isbn, &title, &pubdate, &publisher, &edition, &copies, &available, &loanMetrics, &coverVersion, &createdAt, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errBookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	authors, err := s.authorsForBooks(ctx, []int{bookID})
+	if err != nil {
+		return nil, err
+	}
+	tags, err := s.tagsForBooks(ctx, []int{bookID})
+	if err != nil {
+		return nil, err
+	}
+	subjects, err := s.subjectsForBooks(ctx, []int{bookID})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"id":          bookID,
+		"isbn":        nullString(isbn),
+		"title":       title,
+		"pubdate":     nullString(pubdate),
+		"publisher":   nullString(publisher),
+		"edition":     nullString(edition),
+		"copies":      copies,
+		"available":   available,
+		"loanMetrics": loanMetrics,
+		"coverUrl":    coverURL(bookID, coverVersion),
+		"createdAt":   createdAt,
+		"updatedAt":   updatedAt,
+		"authors":     nonNil(authors[bookID]),
+		"tags":        nonNil(tags[bookID]),
+		"subjects":    nonNil(subjects[bookID]),
+	}, nil
+}
+
+// optional text columns of books and how long they can be, see bobbytables.sql
+var bookTextFields = []struct {
+	name   string
+	maxLen int
+}{
+	{"pubdate", 10},
+	{"publisher", 64},
+	{"edition", 64},
+}
+
+// parseBookPatch turns a merge patch into SET clauses. a field that's left out stays as it is,
+// null (or "") clears it. title and copies can't be cleared. the new copy count is returned
+// separately (-1 if unchanged) because updateBook has to check it against the loans.
+func parseBookPatch(body map[string]json.RawMessage) ([]string, []interface{}, int, error) {
+	var sets []string
+	var args []interface{}
+	copies := -1
+
+	for key := range body {
+		switch key {
+		case "isbn", "title", "pubdate", "publisher", "edition", "copies":
+		default:
+			return nil, nil, 0, fmt.Errorf("%w: unknown field %s", errInvalidBook, key)
+		}
+	}
+
+	if raw, ok := body["title"]; ok {
+		var title string
+		if err := json.Unmarshal(raw, &title); err != nil || strings.TrimSpace(title) == "" {
+			return nil, nil, 0, fmt.Errorf("%w: title must be a non-empty string", errInvalidBook)
+		}
+		title = strings.TrimSpace(title)
+		if utf8.RuneCountInString(title) > 255 {
+			return nil, nil, 0, fmt.Errorf("%w: title can be at most 255 characters", errInvalidBook)
+		}
+		sets = append(sets, "title = ?")
+		args = append(args, title)
+	}
+
+	for _, field := range bookTextFields {
+		raw, ok := body[field.name]
+		if !ok {
+			continue
+		}
+		var value *string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, nil, 0, fmt.Errorf("%w: %s must be a string or null", errInvalidBook, field.name)
+		}
+		if value != nil {
+			trimmed := strings.TrimSpace(*value)
+			value = &trimmed
+			if trimmed == "" {
+				value = nil
+			}
+		}
+		if value != nil && utf8.RuneCountInString(*value) > field.maxLen {
+			return nil, nil, 0, fmt.Errorf("%w: %s can be at most %d characters", errInvalidBook, field.name, field.maxLen)
+		}
+		if value != nil && field.name == "pubdate" {
+			if _, err := time.Parse("2006-01-02", *value); err != nil {
+				return nil, nil, 0, fmt.Errorf("%w: pubdate must look like 2006-01-02", errInvalidBook)
+			}
+		}
+		sets = append(sets, field.name+" = ?")
+		args = append(args, value)
+	}
+
+	if raw, ok := body["isbn"]; ok {
+		var value *string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, nil, 0, fmt.Errorf("%w: isbn must be a string or null", errInvalidBook)
+		}
+		if value != nil && strings.TrimSpace(*value) == "" {
+			value = nil
+		}
+		if value != nil {
+			isbn, err := normalizeISBN(*value)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			value = &isbn
+		}
+		sets = append(sets, "isbn = ?")
+		args = append(args, value)
+	}
+
+	if raw, ok := body["copies"]; ok {
+		if err := json.Unmarshal(raw, &copies); err != nil || copies <= 0 {
+			return nil, nil, 0, fmt.Errorf("%w: copies must be a positive whole number", errInvalidBook)
+		}
+		sets = append(sets, "copies = ?")
+		args = append(args, copies)
+	}
+
+	if len(sets) == 0 {
+		return nil, nil, 0, fmt.Errorf("%w: nothing to update", errInvalidBook)
+	}
+	return sets, args, copies, nil
+}
+
+// updateBook applies a parsed patch. when copies changes it's checked against the copies that are
+// out on loan or on the hold shelf, and if it went up the next people in the hold queue get theirs.
+func (s *Server) updateBook(ctx context.Context, bookID int, sets []string, args []interface{}, copies int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current int
+	err = tx.QueryRowContext(ctx, `SELECT copies FROM books WHERE bookID = ? FOR UPDATE`, bookID).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errBookNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if copies >= 0 {
+		free, err := freeCopies(ctx, tx, bookID, "")
+		if err != nil {
+			return err
+		}
+		if inUse := current - free; copies < inUse {
+			return fmt.Errorf("%w: %d copies are on loan or waiting for pickup, copies can't go below that", errCopiesInUse, inUse)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE books SET `+strings.Join(sets, ", ")+` WHERE bookID = ?`, append(args, bookID)...,
+	); err != nil {
+		return err
+	}
+	if copies > current {
+		if err := s.promoteHolds(ctx, tx, bookID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// deleteBook deletes a book nobody has borrowed or held. loan and hold rows are kept as history and
+// still reference it, so for any other book the error says what's in the way
+func (s *Server) deleteBook(ctx context.Context, bookID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx, `SELECT bookID FROM books WHERE bookID = ? FOR UPDATE`, bookID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errBookNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var onLoan, waiting, history int
+	err = tx.QueryRowContext(ctx, `
+        SELECT
+            (SELECT COUNT(*) FROM loan WHERE bookID = ? AND returnDate IS NULL),
+            (SELECT COUNT(*) FROM holds WHERE bookID = ? AND status IN ('waiting', 'ready')),
+            (SELECT COUNT(*) FROM loan WHERE bookID = ?) + (SELECT COUNT(*) FROM holds WHERE bookID = ?)`,
+		bookID, bookID, bookID, bookID,
+	).Scan(&onLoan, &waiting, &history)
+	if err != nil {
+		return err
+	}
+	switch {
+	case onLoan > 0:
+		return fmt.Errorf("%w: %d copies are still on loan", errBookInUse, onLoan)
+	case waiting > 0:
+		return fmt.Errorf("%w: %d holds are waiting or ready for pickup, cancel them first", errBookInUse, waiting)
+	case history > 0:
+		return fmt.Errorf("%w: it has been lent or held before, set copies to 0 to take it out of circulation instead", errBookInUse)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM books WHERE bookID = ?`, bookID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// touchBooks bumps updatedAt on the books matching where. edits to the books row bump it on their own
+// (ON UPDATE), this is for the metadata that lives in other tables: authors, tags and subjects.
+// it's how OAI-PMH harvesters find out a record changed
+func touchBooks(ctx context.Context, db interface {
+	ExecContext(context.Context, string, ...any) (sql.Result, error)
+}, where string, args ...any) error {
+	_, err := db.ExecContext(ctx, `UPDATE books SET updatedAt = NOW() WHERE `+where, args...)
+	return err
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDeleteBook(t *testing.T) {
+	tests := []struct {
+		name    string
+		found   bool
+		counts  []driver.Value //copies on loan, active holds, loans and holds ever
+		wantErr error
+		want    string
+	}{
+		{"never lent", true, []driver.Value{int64(0), int64(0), int64(0)}, nil, ""},
+		{"missing", false, nil, errBookNotFound, ""},
+		{"on loan", true, []driver.Value{int64(2), int64(1), int64(5)}, errBookInUse, "2 copies are still on loan"},
+		{"held", true, []driver.Value{int64(0), int64(1), int64(3)}, errBookInUse, "1 holds are waiting or ready for pickup"},
+		{"returned", true, []driver.Value{int64(0), int64(0), int64(3)}, errBookInUse, "set copies to 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleted := false
+			s := newTestServer(t, func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+				switch {
+				case strings.Contains(query, "FOR UPDATE"):
+					if !tt.found {
+						return []string{"bookID"}, nil, nil
+					}
+					return []string{"bookID"}, [][]driver.Value{{int64(7)}}, nil
+				case strings.Contains(query, "COUNT(*)"):
+					return []string{"onLoan", "waiting", "history"}, [][]driver.Value{tt.counts}, nil
+				case strings.HasPrefix(query, "DELETE FROM books"):
+					deleted = true
+					return nil, nil, nil
+				}
+				t.Errorf("unexpected query %s", query)
+				return nil, nil, errors.New("unexpected query")
+			})
+			err := s.deleteBook(context.Background(), 7)
+			if !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != nil {
+				t.Fatalf("deleteBook = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("deleteBook = %v, want it to say %q", err, tt.want)
+			}
+			if deleted != (tt.wantErr == nil) {
+				t.Errorf("book deleted: %v", deleted)
+			}
+		})
+	}
+}
+
+func TestParseBookPatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		sets   []string
+		args   []interface{}
+		copies int
+	}{
+		//only what's in the body is set, everything else is left alone
+		{"title", `{"title": "  Zami  "}`, []string{"title = ?"}, []interface{}{"Zami"}, -1},
+		{"copies", `{"copies": 3}`, []string{"copies = ?"}, []interface{}{3}, 3},
+		{"null clears", `{"publisher": null}`, []string{"publisher = ?"}, []interface{}{(*string)(nil)}, -1},
+		{"blank clears", `{"edition": "  ", "isbn": ""}`, []string{"edition = ?", "isbn = ?"}, []interface{}{(*string)(nil), (*string)(nil)}, -1},
+		{
+			"every field",
+			`{"isbn": "0-306-40615-2", "title": "Zami", "pubdate": "1982-01-01", "publisher": " Crossing Press ", "edition": "1st", "copies": 2}`,
+			[]string{"title = ?", "pubdate = ?", "publisher = ?", "edition = ?", "isbn = ?", "copies = ?"},
+			[]interface{}{"Zami", ptr("1982-01-01"), ptr("Crossing Press"), ptr("1st"), ptr("9780306406157"), 2},
+			2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(tt.body), &body); err != nil {
+				t.Fatal(err)
+			}
+			sets, args, copies, err := parseBookPatch(body)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(sets, tt.sets) || !reflect.DeepEqual(args, tt.args) || copies != tt.copies {
+				t.Errorf("got %q %v %d, want %q %v %d", sets, args, copies, tt.sets, tt.args, tt.copies)
+			}
+		})
+	}
+}
+
+func TestParseBookPatchInvalid(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{}`, "nothing to update"},
+		{`{"author": "Lorde"}`, "unknown field author"},
+		//title and copies can't be cleared
+		{`{"title": null}`, "title must be a non-empty string"},
+		{`{"title": "  "}`, "title must be a non-empty string"},
+		{`{"title": 5}`, "title must be a non-empty string"},
+		{`{"copies": null}`, "copies must be a positive whole number"},
+		{`{"copies": 0}`, "copies must be a positive whole number"},
+		{`{"copies": 1.5}`, "copies must be a positive whole number"},
+		{`{"title": "` + strings.Repeat("é", 256) + `"}`, "title can be at most 255 characters"},
+		{`{"publisher": 5}`, "publisher must be a string or null"},
+		{`{"edition": "` + strings.Repeat("x", 65) + `"}`, "edition can be at most 64 characters"},
+		{`{"pubdate": "1982"}`, "pubdate must look like 2006-01-02"},
+		{`{"isbn": false}`, "isbn must be a string or null"},
+		{`{"isbn": "0306406153"}`, "check digit should be 2"},
+	}
+	for _, tt := range tests {
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(tt.body), &body); err != nil {
+			t.Fatal(err)
+		}
+		_, _, _, err := parseBookPatch(body)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.body, err, tt.want)
+		}
+	}
+}
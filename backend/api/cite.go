@@ -0,0 +1,269 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const maxCiteBatch = 100
+
+// the formats /cite speaks and the Content-Type each one is served as
+var citeFormats = map[string]string{
+	"bibtex":   "application/x-bibtex; charset=utf-8",
+	"ris":      "application/x-research-info-systems; charset=utf-8",
+	"csl-json": "application/vnd.citationstyles.csl+json; charset=utf-8",
+}
+
+// GET /books/{id}/cite?format=bibtex|ris|csl-json, bibtex by default
+func (s *Server) handleBookCite(w http.ResponseWriter, r *http.Request, id string) {
+	bookID, err := strconv.Atoi(id)
+	if err != nil {
+		http.Error(w, "invalid book id", http.StatusBadRequest)
+		return
+	}
+	s.writeCitations(w, r, []int{bookID})
+}
+
+// GET /books/cite?ids=1001,1002&format=ris cites several books at once, in the order given
+func (s *Server) handleBatchCite() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ids []int
+		for _, raw := range strings.Split(r.URL.Query().Get("ids"), ",") {
+			if raw = strings.TrimSpace(raw); raw == "" {
+				continue
+			}
+			id, err := strconv.Atoi(raw)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid book id %q", raw), http.StatusBadRequest)
+				return
+			}
+			ids = append(ids, id)
+		}
+		if len(ids) == 0 {
+			http.Error(w, "missing ids", http.StatusBadRequest)
+			return
+		}
+		if len(ids) > maxCiteBatch {
+			http.Error(w, fmt.Sprintf("at most %d ids per request", maxCiteBatch), http.StatusBadRequest)
+			return
+		}
+		s.writeCitations(w, r, ids)
+	})
+}
+
+func (s *Server) writeCitations(w http.ResponseWriter, r *http.Request, ids []int) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "bibtex"
+	}
+	contentType, ok := citeFormats[format]
+	if !ok {
+		http.Error(w, "format must be bibtex, ris or csl-json", http.StatusBadRequest)
+		return
+	}
+
+	found, _, err := s.queryBooksWithFilters(r.Context(), BookFilters{IDs: ids}, PaginationParams{Limit: len(ids)})
+	if err != nil {
+		http.Error(w, "query failed", http.StatusInternalServerError)
+		return
+	}
+	byID := make(map[int]book, len(found))
+	for _, b := range found {
+		byID[b.ID] = b
+	}
+	var books []book
+	var missing []string
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		b, ok := byID[id]
+		if !ok {
+			missing = append(missing, strconv.Itoa(id))
+			continue
+		}
+		if !seen[id] {
+			seen[id] = true
+			books = append(books, b)
+		}
+	}
+	if len(missing) > 0 {
+		http.Error(w, "unknown book ids: "+strings.Join(missing, ", "), http.StatusNotFound)
+		return
+	}
+
+	if format == "csl-json" {
+		items := make([]map[string]any, len(books))
+		for i, b := range books {
+			items[i] = cslItem(b)
+		}
+		w.Header().Set("Content-Type", contentType)
+		json.NewEncoder(w).Encode(items)
+		return
+	}
+
+	var sb strings.Builder
+	keys := make(map[string]bool)
+	for _, b := range books {
+		if format == "ris" {
+			writeRIS(&sb, b)
+		} else {
+			writeBibTeX(&sb, b, keys)
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write([]byte(sb.String()))
+}
+
+func pubYear(b book) string {
+	if b.PubDate != nil && len(*b.PubDate) >= 4 {
+		return (*b.PubDate)[:4]
+	}
+	return ""
+}
+
+// bibtexKey is the usual lastname + year + first title word, ascii only. a key already used in
+// this response gets the first of a, b, ... z, aa, ab... that makes it unused, which can't clash
+// with another book's plain key either since those are checked against used as well
+func bibtexKey(b book, used map[string]bool) string {
+	var sb strings.Builder
+	ascii := func(s string) {
+		for _, r := range norm.NFD.String(strings.ToLower(s)) {
+			if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	if len(b.Authors) > 0 {
+		ascii(b.Authors[0].LName)
+	}
+	ascii(pubYear(b))
+	for _, word := range strings.Fields(b.Title) {
+		if w := strings.ToLower(word); w != "the" && w != "a" && w != "an" {
+			ascii(word)
+			break
+		}
+	}
+	key := sb.String()
+	if key == "" {
+		key = "book" + strconv.Itoa(b.ID)
+	}
+	base := key
+	for n := 0; used[key]; n++ {
+		key = base + keySuffix(n)
+	}
+	used[key] = true
+	return key
+}
+
+// keySuffix is a, b, ... z, aa, ab... for n = 0, 1, ...
+func keySuffix(n int) string {
+	var suffix []byte
+	for n++; n > 0; n = (n - 1) / 26 {
+		suffix = append([]byte{byte('a' + (n-1)%26)}, suffix...)
+	}
+	return string(suffix)
+}
+
+var bibtexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`, `{`, `\{`, `}`, `\}`, `&`, `\&`, `%`, `\%`, `$`, `\$`,
+	`#`, `\#`, `_`, `\_`, `~`, `\textasciitilde{}`, `^`, `\textasciicircum{}`,
+)
+
+func writeBibTeX(sb *strings.Builder, b book, used map[string]bool) {
+	fmt.Fprintf(sb, "@book{%s,\n", bibtexKey(b, used))
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(sb, "  %s = {%s},\n", name, value)
+		}
+	}
+	var authors []string
+	for _, a := range b.Authors {
+		name := bibtexEscaper.Replace(a.LName)
+		if a.FName != nil {
+			name += ", " + bibtexEscaper.Replace(*a.FName)
+		} else {
+			//keeps a single name from being read as a first name
+			name = "{" + name + "}"
+		}
+		authors = append(authors, name)
+	}
+	field("author", strings.Join(authors, " and "))
+	//double braces keep the title's capitalization as is
+	field("title", "{"+bibtexEscaper.Replace(b.Title)+"}")
+	field("edition", bibtexEscaper.Replace(nullable(b.Edition)))
+	field("publisher", bibtexEscaper.Replace(nullable(b.Publisher)))
+	field("year", pubYear(b))
+	field("isbn", nullable(b.ISBN))
+	sb.WriteString("}\n\n")
+}
+
+// a line break inside a value would end the tag early
+var risLineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+func writeRIS(sb *strings.Builder, b book) {
+	//RIS wants CRLF and "XX  - " tags
+	tag := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(sb, "%s  - %s\r\n", name, risLineBreaks.Replace(value))
+		}
+	}
+	tag("TY", "BOOK")
+	for _, a := range b.Authors {
+		name := a.LName
+		if a.FName != nil {
+			name += ", " + *a.FName
+		}
+		tag("AU", name)
+	}
+	tag("TI", b.Title)
+	tag("ET", nullable(b.Edition))
+	tag("PB", nullable(b.Publisher))
+	tag("PY", pubYear(b))
+	tag("SN", nullable(b.ISBN))
+	for _, t := range b.Tags {
+		tag("KW", t)
+	}
+	tag("ID", strconv.Itoa(b.ID))
+	sb.WriteString("ER  - \r\n\r\n")
+}
+
+func cslItem(b book) map[string]any {
+	item := map[string]any{
+		"id":    strconv.Itoa(b.ID),
+		"type":  "book",
+		"title": b.Title,
+	}
+	var authors []map[string]string
+	for _, a := range b.Authors {
+		if a.FName != nil {
+			authors = append(authors, map[string]string{"family": a.LName, "given": *a.FName})
+		} else {
+			authors = append(authors, map[string]string{"literal": a.LName})
+		}
+	}
+	if authors != nil {
+		item["author"] = authors
+	}
+	if year, err := strconv.Atoi(pubYear(b)); err == nil {
+		item["issued"] = map[string]any{"date-parts": [][]int{{year}}}
+	}
+	if b.Publisher != nil {
+		item["publisher"] = *b.Publisher
+	}
+	if b.Edition != nil {
+		item["edition"] = *b.Edition
+	}
+	if b.ISBN != nil {
+		item["ISBN"] = *b.ISBN
+	}
+	return item
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestBibtexKey(t *testing.T) {
+	lorde := []author{{LName: "Lorde", FName: ptr("Audre")}}
+	zami := book{ID: 1, Title: "Zami: A New Spelling of My Name", PubDate: ptr("1982-01-01"), Authors: lorde}
+
+	tests := []struct {
+		name  string
+		books []book
+		want  []string
+	}{
+		{"plain", []book{zami}, []string{"lorde1982zami"}},
+		{"skips leading article", []book{{Title: "The Cancer Journals", PubDate: ptr("1980"), Authors: lorde}}, []string{"lorde1980cancer"}},
+		{"folds accents", []book{{Title: "Éclat", Authors: []author{{LName: "Núñez"}}}}, []string{"nunezeclat"}},
+		{"no author, year or title", []book{{ID: 42}}, []string{"book42"}},
+		{"collisions", []book{zami, zami, zami}, []string{"lorde1982zami", "lorde1982zamia", "lorde1982zamib"}},
+		{
+			"plain key equal to an earlier suffixed key",
+			[]book{zami, zami, {Title: "Zamia", PubDate: ptr("1982"), Authors: lorde}},
+			[]string{"lorde1982zami", "lorde1982zamia", "lorde1982zamiaa"},
+		},
+		{
+			"suffixed key equal to an earlier plain key",
+			[]book{{Title: "Zamia", PubDate: ptr("1982"), Authors: lorde}, zami, zami},
+			[]string{"lorde1982zamia", "lorde1982zami", "lorde1982zamib"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			used := make(map[string]bool)
+			for i, b := range tt.books {
+				if got := bibtexKey(b, used); got != tt.want[i] {
+					t.Errorf("book %d: got %q, want %q", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBibtexKeyPast26(t *testing.T) {
+	used := make(map[string]bool)
+	b := book{Title: "Zami", Authors: []author{{LName: "Lorde"}}}
+	for i := 0; i < 30; i++ {
+		key := bibtexKey(b, used)
+		switch i {
+		case 26:
+			if key != "lordezamiz" {
+				t.Errorf("27th key: got %q", key)
+			}
+		case 27:
+			if key != "lordezamiaa" {
+				t.Errorf("28th key: got %q", key)
+			}
+		}
+	}
+	if len(used) != 30 {
+		t.Errorf("got %d distinct keys for 30 books", len(used))
+	}
+}
+
+func TestKeySuffix(t *testing.T) {
+	for n, want := range map[int]string{0: "a", 25: "z", 26: "aa", 27: "ab", 51: "az", 52: "ba", 701: "zz", 702: "aaa"} {
+		if got := keySuffix(n); got != want {
+			t.Errorf("keySuffix(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestWriteBibTeX(t *testing.T) {
+	b := book{
+		ID:        7,
+		ISBN:      ptr("9780895941220"),
+		Title:     `Stone Butch Blues: 50% off & {more} #1_a ~^\`,
+		PubDate:   ptr("1993-03-01"),
+		Publisher: ptr("Firebrand & Co"),
+		Edition:   ptr("2nd"),
+		Authors:   []author{{LName: "Feinberg", FName: ptr("Leslie")}, {LName: "Kai"}},
+	}
+	var sb strings.Builder
+	writeBibTeX(&sb, b, make(map[string]bool))
+	want := "@book{feinberg1993stone,\n" +
+		"  author = {Feinberg, Leslie and {Kai}},\n" +
+		`  title = {{Stone Butch Blues: 50\% off \& \{more\} \#1\_a \textasciitilde{}\textasciicircum{}\textbackslash{}}},` + "\n" +
+		"  edition = {2nd},\n" +
+		"  publisher = {Firebrand \\& Co},\n" +
+		"  year = {1993},\n" +
+		"  isbn = {9780895941220},\n" +
+		"}\n\n"
+	if got := sb.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	sb.Reset()
+	writeBibTeX(&sb, book{ID: 8, Title: "Untitled"}, make(map[string]bool))
+	if want := "@book{untitled,\n  title = {{Untitled}},\n}\n\n"; sb.String() != want {
+		t.Errorf("missing fields: got %q, want %q", sb.String(), want)
+	}
+}
+
+func TestWriteRIS(t *testing.T) {
+	b := book{
+		ID:        7,
+		ISBN:      ptr("9780895941220"),
+		Title:     "Stone Butch\r\nBlues\nor\rnot",
+		PubDate:   ptr("1993"),
+		Publisher: ptr("Firebrand"),
+		Authors:   []author{{LName: "Feinberg", FName: ptr("Leslie")}, {LName: "Kai"}},
+		Tags:      []string{"fiction", "trans"},
+	}
+	var sb strings.Builder
+	writeRIS(&sb, b)
+	want := "TY  - BOOK\r\n" +
+		"AU  - Feinberg, Leslie\r\n" +
+		"AU  - Kai\r\n" +
+		"TI  - Stone Butch Blues or not\r\n" +
+		"PB  - Firebrand\r\n" +
+		"PY  - 1993\r\n" +
+		"SN  - 9780895941220\r\n" +
+		"KW  - fiction\r\n" +
+		"KW  - trans\r\n" +
+		"ID  - 7\r\n" +
+		"ER  - \r\n\r\n"
+	if got := sb.String(); got != want {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+}
+
+func TestCSLItem(t *testing.T) {
+	tests := []struct {
+		name string
+		b    book
+		want string
+	}{
+		{
+			"full",
+			book{
+				ID:        7,
+				ISBN:      ptr("9780895941220"),
+				Title:     "Stone Butch Blues",
+				PubDate:   ptr("1993-03-01"),
+				Publisher: ptr("Firebrand"),
+				Edition:   ptr("2nd"),
+				Authors:   []author{{LName: "Feinberg", FName: ptr("Leslie")}, {LName: "Kai"}},
+			},
+			`{"ISBN":"9780895941220","author":[{"family":"Feinberg","given":"Leslie"},{"literal":"Kai"}],` +
+				`"edition":"2nd","id":"7","issued":{"date-parts":[[1993]]},"publisher":"Firebrand","title":"Stone Butch Blues","type":"book"}`,
+		},
+		{
+			"only a title",
+			book{ID: 8, Title: "Untitled"},
+			`{"id":"8","title":"Untitled","type":"book"}`,
+		},
+		{
+			"year that isn't a number",
+			book{ID: 9, Title: "Untitled", PubDate: ptr("19??")},
+			`{"id":"9","title":"Untitled","type":"book"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(cslItem(tt.b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
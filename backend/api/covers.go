@@ -0,0 +1,244 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	maxCoverBytes = 5 << 20 //5MB, bookCovers.image is a mediumblob (16MB)
+	//decoding allocates width*height*4 bytes, refuse anything bigger than a generous scan before decoding it
+	maxCoverPixels = 40_000_000
+	coverQuality   = 85
+)
+
+// the types we accept, keyed by what http.DetectContentType sniffs from the upload.
+// whatever Content-Type the client claims is ignored
+var coverTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// thumbnails are scaled to fit the box (never up) and always stored as jpeg
+var coverSizes = []struct {
+	name          string
+	width, height int
+}{
+	{"small", 80, 120},
+	{"medium", 200, 300},
+	{"large", 400, 600},
+}
+
+const defaultCoverSize = "medium"
+
+var (
+	errCoverType    = errors.New("cover must be a jpeg, png or webp image")
+	errCoverTooBig  = fmt.Errorf("cover can be at most %d MB", maxCoverBytes>>20)
+	errCoverInvalid = errors.New("cover image could not be decoded")
+)
+
+// coverVersionExpr is the cover's last upload time (NULL if there's no cover) for a query over books,
+// it goes in the cover url so browsers drop their cached copy when a new one is uploaded
+const coverVersionExpr = `(SELECT UNIX_TIMESTAMP(MAX(c.updatedAt)) FROM bookCovers c WHERE c.bookID = books.bookID)`
+
+func coverURL(bookID int, version sql.NullInt64) *string {
+	if !version.Valid {
+		return nil
+	}
+	url := fmt.Sprintf("/api/v1/books/%d/cover?v=%d", bookID, version.Int64)
+	return &url
+}
+
+// GET /books/{id}/cover?size=small|medium|large|original (medium by default)
+// PUT /books/{id}/cover with the image as the raw request body, DELETE to remove it
+func (s *Server) handleBookCover(w http.ResponseWriter, r *http.Request, id string) {
+	bookID, err := strconv.Atoi(id)
+	if err != nil {
+		http.Error(w, "invalid book id", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		size := r.URL.Query().Get("size")
+		if size == "" {
+			size = defaultCoverSize
+		}
+		if !validCoverSize(size) {
+			http.Error(w, "size must be small, medium, large or original", http.StatusBadRequest)
+			return
+		}
+		var (
+			contentType string
+			data        []byte
+			updatedAt   time.Time
+		)
+		err := s.db.QueryRowContext(r.Context(), `
+            SELECT contentType, image, updatedAt FROM bookCovers WHERE bookID = ? AND size = ?`, bookID, size,
+		).Scan(&contentType, &data, &updatedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.Header().Set("ETag", fmt.Sprintf(`"%d-%s-%d"`, bookID, size, updatedAt.Unix()))
+		//handles If-None-Match/If-Modified-Since, ranges and Content-Length for us
+		http.ServeContent(w, r, "", updatedAt, bytes.NewReader(data))
+
+	case http.MethodPut:
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCoverBytes))
+		if err != nil {
+			var tooBig *http.MaxBytesError
+			if errors.As(err, &tooBig) {
+				http.Error(w, errCoverTooBig.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "could not read upload", http.StatusBadRequest)
+			return
+		}
+		if err := s.saveCover(r.Context(), bookID, data); err != nil {
+			switch {
+			case errors.Is(err, errBookNotFound):
+				http.NotFound(w, r)
+			case errors.Is(err, errCoverType):
+				http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			case errors.Is(err, errCoverInvalid):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				log.Printf("save cover: %v", err)
+				http.Error(w, "upload failed", http.StatusInternalServerError)
+			}
+			return
+		}
+		var version sql.NullInt64
+		s.db.QueryRowContext(r.Context(), `SELECT `+coverVersionExpr+` FROM books WHERE bookID = ?`, bookID).Scan(&version)
+		writeJSON(w, http.StatusOK, map[string]any{"coverUrl": coverURL(bookID, version)})
+
+	case http.MethodDelete:
+		res, err := s.db.ExecContext(r.Context(), `DELETE FROM bookCovers WHERE bookID = ?`, bookID)
+		if err != nil {
+			http.Error(w, "delete failed", http.StatusInternalServerError)
+			return
+		}
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func validCoverSize(size string) bool {
+	if size == "original" {
+		return true
+	}
+	for _, cs := range coverSizes {
+		if cs.name == size {
+			return true
+		}
+	}
+	return false
+}
+
+// saveCover checks an upload, makes the thumbnails and replaces whatever cover the book had
+func (s *Server) saveCover(ctx context.Context, bookID int, data []byte) error {
+	contentType := http.DetectContentType(data)
+	if !coverTypes[contentType] {
+		return errCoverType
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return errCoverInvalid
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxCoverPixels {
+		return fmt.Errorf("%w: %dx%d is too large", errCoverInvalid, cfg.Width, cfg.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return errCoverInvalid
+	}
+
+	thumbs := make(map[string][]byte, len(coverSizes))
+	for _, cs := range coverSizes {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, fitCover(src, cs.width, cs.height), &jpeg.Options{Quality: coverQuality}); err != nil {
+			return err
+		}
+		thumbs[cs.name] = buf.Bytes()
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked int
+	err = tx.QueryRowContext(ctx, `SELECT bookID FROM books WHERE bookID = ? FOR UPDATE`, bookID).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errBookNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM bookCovers WHERE bookID = ?`, bookID); err != nil {
+		return err
+	}
+	insert := func(size, contentType string, image []byte) error {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO bookCovers (bookID, size, contentType, image, updatedAt) VALUES (?, ?, ?, ?, NOW())`,
+			bookID, size, contentType, image,
+		)
+		return err
+	}
+	if err := insert("original", contentType, data); err != nil {
+		return err
+	}
+	for _, cs := range coverSizes {
+		if err := insert(cs.name, "image/jpeg", thumbs[cs.name]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// fitCover scales src down to fit in width x height keeping its aspect ratio. transparent
+// parts of a png/webp end up white since jpeg has no alpha
+func fitCover(src image.Image, width, height int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > width || h > height {
+		if w*height > h*width {
+			w, h = width, max(1, h*width/w)
+		} else {
+			w, h = max(1, w*height/h), height
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
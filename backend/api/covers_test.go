@@ -0,0 +1,55 @@
+package api
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFitCover(t *testing.T) {
+	tests := []struct {
+		name          string
+		src           image.Rectangle
+		width, height int
+		want          image.Point
+	}{
+		{"portrait", image.Rect(0, 0, 1000, 1500), 200, 300, image.Pt(200, 300)},
+		{"taller than the box", image.Rect(0, 0, 500, 1000), 200, 300, image.Pt(150, 300)},
+		{"wider than the box", image.Rect(0, 0, 1000, 500), 200, 300, image.Pt(200, 100)},
+		{"only too tall", image.Rect(0, 0, 100, 600), 200, 300, image.Pt(50, 300)},
+		{"only too wide", image.Rect(0, 0, 800, 100), 200, 300, image.Pt(200, 25)},
+		{"small ones aren't scaled up", image.Rect(0, 0, 60, 90), 200, 300, image.Pt(60, 90)},
+		{"a sliver keeps a pixel", image.Rect(0, 0, 10000, 10), 80, 120, image.Pt(80, 1)},
+		{"bounds not at the origin", image.Rect(50, 50, 450, 650), 80, 120, image.Pt(80, 120)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fitCover(image.NewRGBA(tt.src), tt.width, tt.height).Bounds()
+			if got != (image.Rectangle{Max: tt.want}) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFitCoverColors(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 60))
+	//the left half is opaque red, the right half stays transparent
+	for y := 0; y < 60; y++ {
+		for x := 0; x < 20; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	dst := fitCover(src, 20, 30)
+	for _, c := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{2, 15, color.RGBA{R: 255, A: 255}},
+		{17, 15, color.RGBA{R: 255, G: 255, B: 255, A: 255}},
+	} {
+		if got := color.RGBAModel.Convert(dst.At(c.x, c.y)); got != c.want {
+			t.Errorf("pixel %d,%d: got %v, want %v", c.x, c.y, got, c.want)
+		}
+	}
+}
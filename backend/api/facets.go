@@ -0,0 +1,265 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bxb454/csds-395-lgbt-library-catalog/search"
+)
+
+const maxFacetValues = 20
+
+// facets /search reports, in order. each name is also the query param that selects its values
+var facetNames = []string{"tag", "authorID", "publisher", "decade", "available"}
+
+// facetValue is one sidebar entry, Value is what goes back in the query param to select it
+type facetValue struct {
+	Value    string `json:"value"`
+	Label    string `json:"label"`
+	Count    int    `json:"count"`
+	Selected bool   `json:"selected"`
+}
+
+// facetSelection is facet name -> selected values. values of one facet are OR'd, facets are AND'd
+type facetSelection map[string]map[string]bool
+
+// parseFacetSelection reads ?tag=&authorID=&publisher=&decade=&available=, each repeatable
+// except available
+func parseFacetSelection(q url.Values) (facetSelection, error) {
+	sel := make(facetSelection)
+	for _, name := range facetNames {
+		for _, raw := range q[name] {
+			value := strings.TrimSpace(raw)
+			if value == "" {
+				continue
+			}
+			switch name {
+			case "tag":
+				tag, err := normalizeTag(value)
+				if err != nil {
+					return nil, err
+				}
+				value = tag
+			case "authorID":
+				id, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid author id %q", raw)
+				}
+				value = strconv.Itoa(id)
+			case "decade":
+				year, err := strconv.Atoi(strings.TrimSuffix(value, "s"))
+				if err != nil || year%10 != 0 {
+					return nil, fmt.Errorf("decade must look like 1980, got %q", raw)
+				}
+				value = strconv.Itoa(year)
+			case "available":
+				available, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("available must be true or false, got %q", raw)
+				}
+				value = strconv.FormatBool(available)
+			}
+			if sel[name] == nil {
+				sel[name] = make(map[string]bool)
+			}
+			sel[name][value] = true
+		}
+	}
+	return sel, nil
+}
+
+func facetValuesOf(d search.Doc, available bool) map[string][]string {
+	values := map[string][]string{
+		"tag":       d.Tags,
+		"available": {strconv.FormatBool(available)},
+	}
+	for _, id := range d.AuthorIDs {
+		values["authorID"] = append(values["authorID"], strconv.Itoa(id))
+	}
+	if d.Publisher != "" {
+		values["publisher"] = []string{d.Publisher}
+	}
+	if d.Year > 0 {
+		values["decade"] = []string{strconv.Itoa(d.Year / 10 * 10)}
+	}
+	return values
+}
+
+// facetHits narrows hits down to the selection and counts every facet. a facet is counted over the
+// hits that pass every other facet's selection but not its own, so with one tag picked the sidebar
+// still shows how many books each other tag would add
+func (s *Server) facetHits(ctx context.Context, hits []search.Hit, sel facetSelection) ([]search.Hit, map[string][]facetValue, error) {
+	ids := make([]int, len(hits))
+	for i, h := range hits {
+		ids[i] = h.ID
+	}
+	available, err := s.availableBooks(ctx, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	docs := make(map[int]search.Doc, len(hits))
+	for _, d := range s.index.Docs(ids) {
+		docs[d.ID] = d
+	}
+	kept, facets := countFacets(hits, docs, available, sel)
+	if err := s.labelAuthorFacet(ctx, facets["authorID"]); err != nil {
+		return nil, nil, err
+	}
+	return kept, facets, nil
+}
+
+// countFacets is facetHits once the docs and their availability are looked up
+func countFacets(hits []search.Hit, docs map[int]search.Doc, available map[int]bool, sel facetSelection) ([]search.Hit, map[string][]facetValue) {
+	counts := make(map[string]map[string]int)
+	for _, name := range facetNames {
+		counts[name] = make(map[string]int)
+	}
+	var kept []search.Hit
+	for _, h := range hits {
+		values := facetValuesOf(docs[h.ID], available[h.ID])
+		var failed []string
+		for _, name := range facetNames {
+			if !matchesFacet(values[name], sel[name]) {
+				failed = append(failed, name)
+			}
+		}
+		if len(failed) == 0 {
+			kept = append(kept, h)
+		}
+		for _, name := range facetNames {
+			//it counts toward a facet only if the other facets let it through
+			if len(failed) == 0 || len(failed) == 1 && failed[0] == name {
+				for _, v := range values[name] {
+					counts[name][v]++
+				}
+			}
+		}
+	}
+
+	facets := make(map[string][]facetValue)
+	for _, name := range facetNames {
+		facets[name] = topFacetValues(name, counts[name], sel[name])
+	}
+	return kept, facets
+}
+
+func matchesFacet(values []string, selected map[string]bool) bool {
+	if len(selected) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if selected[v] {
+			return true
+		}
+	}
+	return false
+}
+
+// topFacetValues keeps the most common values plus anything selected. decades and availability
+// are short lists and read better in order, the rest go by count
+func topFacetValues(name string, counts map[string]int, selected map[string]bool) []facetValue {
+	values := make([]facetValue, 0, len(counts))
+	for v, n := range counts {
+		values = append(values, facetValue{Value: v, Label: v, Count: n, Selected: selected[v]})
+	}
+	for v := range selected {
+		if _, ok := counts[v]; !ok {
+			values = append(values, facetValue{Value: v, Label: v, Selected: true})
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		switch name {
+		case "decade":
+			//numerically, "900" would come before "2010" as a string
+			a, _ := strconv.Atoi(values[i].Value)
+			b, _ := strconv.Atoi(values[j].Value)
+			return a > b
+		case "available":
+			return values[i].Value > values[j].Value
+		}
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Value < values[j].Value
+	})
+
+	var result []facetValue
+	for i, v := range values {
+		if i < maxFacetValues || v.Selected {
+			switch name {
+			case "decade":
+				v.Label = v.Value + "s"
+			case "available":
+				v.Label = map[string]string{"true": "available", "false": "all copies out"}[v.Value]
+			}
+			result = append(result, v)
+		}
+	}
+	return nonNil(result)
+}
+
+// labelAuthorFacet swaps author ids for their preferred names
+func (s *Server) labelAuthorFacet(ctx context.Context, values []facetValue) error {
+	if len(values) == 0 {
+		return nil
+	}
+	ids := make([]int, 0, len(values))
+	for _, v := range values {
+		if id, err := strconv.Atoi(v.Value); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	placeholders, args := inClause(ids)
+	rows, err := s.db.QueryContext(ctx, `SELECT authID, lname, fname FROM authors WHERE authID IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	names := make(map[string]string)
+	for rows.Next() {
+		a, err := scanAuthor(rows)
+		if err != nil {
+			return err
+		}
+		names[strconv.Itoa(a.AuthID)] = authorName(a)
+	}
+	for i := range values {
+		if name, ok := names[values[i].Value]; ok {
+			values[i].Label = name
+		}
+	}
+	return rows.Err()
+}
+
+// availableBooks reports which of ids have a copy on the shelf. availability changes with every
+// checkout so it comes from the db, not the index. ids go in chunks to keep the IN lists short
+func (s *Server) availableBooks(ctx context.Context, ids []int) (map[int]bool, error) {
+	const chunk = 1000
+	available := make(map[int]bool)
+	for start := 0; start < len(ids); start += chunk {
+		placeholders, args := inClause(ids[start:min(start+chunk, len(ids))])
+		rows, err := s.db.QueryContext(ctx, `
+            SELECT bookID FROM books WHERE bookID IN (`+placeholders+`) AND `+availableExpr+` > 0`, args...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			available[id] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return available, nil
+}
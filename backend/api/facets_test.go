@@ -0,0 +1,206 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/bxb454/csds-395-lgbt-library-catalog/search"
+)
+
+func TestParseFacetSelection(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    facetSelection
+		wantErr bool
+	}{
+		{"", facetSelection{}, false},
+		{"tag=Poetry&tag=+trans++history+&tag=", facetSelection{"tag": {"poetry": true, "trans history": true}}, false},
+		{"authorID=007&authorID=3", facetSelection{"authorID": {"7": true, "3": true}}, false},
+		{"decade=1980s&decade=1990", facetSelection{"decade": {"1980": true, "1990": true}}, false},
+		{"available=1", facetSelection{"available": {"true": true}}, false},
+		{"publisher=Firebrand&q=ignored", facetSelection{"publisher": {"Firebrand": true}}, false},
+		{"tag=a/b", nil, true},
+		{"authorID=lorde", nil, true},
+		{"decade=1985", nil, true},
+		{"decade=eighties", nil, true},
+		{"available=maybe", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseFacetSelection(q)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// counts of each facet's values, in the order topFacetValues puts them
+func facetCounts(values []facetValue) string {
+	var s string
+	for _, v := range values {
+		mark := ""
+		if v.Selected {
+			mark = "*"
+		}
+		s += fmt.Sprintf("%s%s=%d ", mark, v.Value, v.Count)
+	}
+	return s
+}
+
+func TestCountFacets(t *testing.T) {
+	docs := map[int]search.Doc{
+		1: {ID: 1, Tags: []string{"poetry", "memoir"}, AuthorIDs: []int{10}, Year: 1982},
+		2: {ID: 2, Tags: []string{"poetry"}, AuthorIDs: []int{10}, Publisher: "Firebrand", Year: 1993},
+		3: {ID: 3, Tags: []string{"fiction"}, AuthorIDs: []int{11}, Publisher: "Firebrand", Year: 1993},
+		4: {ID: 4, Tags: []string{"fiction", "memoir"}, AuthorIDs: []int{12}},
+	}
+	available := map[int]bool{1: true, 3: true}
+	var hits []search.Hit
+	for id := 1; id <= 4; id++ {
+		hits = append(hits, search.Hit{ID: id})
+	}
+
+	tests := []struct {
+		name string
+		sel  facetSelection
+		kept []int
+		want map[string]string
+	}{
+		{
+			"nothing selected",
+			facetSelection{},
+			[]int{1, 2, 3, 4},
+			map[string]string{
+				"tag":       "fiction=2 memoir=2 poetry=2 ",
+				"authorID":  "10=2 11=1 12=1 ",
+				"publisher": "Firebrand=2 ",
+				"decade":    "1990=2 1980=1 ",
+				"available": "true=2 false=2 ",
+			},
+		},
+		{
+			//the tag facet ignores its own selection so the other tags still show what they'd add,
+			//every other facet is counted over the poetry books only
+			"one tag",
+			facetSelection{"tag": {"poetry": true}},
+			[]int{1, 2},
+			map[string]string{
+				"tag":       "fiction=2 memoir=2 *poetry=2 ",
+				"authorID":  "10=2 ",
+				"publisher": "Firebrand=1 ",
+				"decade":    "1990=1 1980=1 ",
+				"available": "true=1 false=1 ",
+			},
+		},
+		{
+			"values of one facet are OR'd",
+			facetSelection{"tag": {"poetry": true, "fiction": true}},
+			[]int{1, 2, 3, 4},
+			map[string]string{
+				"tag":       "*fiction=2 memoir=2 *poetry=2 ",
+				"authorID":  "10=2 11=1 12=1 ",
+				"publisher": "Firebrand=2 ",
+				"decade":    "1990=2 1980=1 ",
+				"available": "true=2 false=2 ",
+			},
+		},
+		{
+			//book 2 fails both and counts toward neither, book 1 fails only the tag and counts toward tags
+			"facets are AND'd",
+			facetSelection{"tag": {"fiction": true}, "available": {"true": true}},
+			[]int{3},
+			map[string]string{
+				"tag":       "*fiction=1 memoir=1 poetry=1 ",
+				"authorID":  "11=1 ",
+				"publisher": "Firebrand=1 ",
+				"decade":    "1990=1 ",
+				"available": "*true=1 false=1 ",
+			},
+		},
+		{
+			"selection with no hits",
+			facetSelection{"decade": {"1970": true}},
+			nil,
+			map[string]string{
+				"tag":       "",
+				"authorID":  "",
+				"publisher": "",
+				"decade":    "1990=2 1980=1 *1970=0 ",
+				"available": "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, facets := countFacets(hits, docs, available, tt.sel)
+			var keptIDs []int
+			for _, h := range kept {
+				keptIDs = append(keptIDs, h.ID)
+			}
+			if !reflect.DeepEqual(keptIDs, tt.kept) {
+				t.Errorf("kept %v, want %v", keptIDs, tt.kept)
+			}
+			for name, want := range tt.want {
+				if got := facetCounts(facets[name]); got != want {
+					t.Errorf("%s: got %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTopFacetValues(t *testing.T) {
+	counts := make(map[string]int)
+	for i := 1; i <= 30; i++ {
+		counts[fmt.Sprintf("tag%02d", i)] = 100 - i
+	}
+	values := topFacetValues("tag", counts, map[string]bool{"tag27": true, "unseen": true})
+	if len(values) != maxFacetValues+2 {
+		t.Fatalf("got %d values, want the top %d and the 2 selected", len(values), maxFacetValues)
+	}
+	if values[0].Value != "tag01" || values[maxFacetValues-1].Value != "tag20" {
+		t.Errorf("top values run %s..%s, want tag01..tag20", values[0].Value, values[maxFacetValues-1].Value)
+	}
+	if got := facetCounts(values[maxFacetValues:]); got != "*tag27=73 *unseen=0 " {
+		t.Errorf("past the cap got %q, want only the selected values", got)
+	}
+
+	//ties go by value
+	if got := facetCounts(topFacetValues("publisher", map[string]int{"b": 2, "c": 5, "a": 2}, nil)); got != "c=5 a=2 b=2 " {
+		t.Errorf("publisher: got %q", got)
+	}
+
+	decades := make(map[string]int)
+	for _, d := range []int{1970, 900, 2010, 1990, 1980} {
+		decades[strconv.Itoa(d)] = d % 7
+	}
+	values = topFacetValues("decade", decades, nil)
+	var order, labels []string
+	for _, v := range values {
+		order = append(order, v.Value)
+		labels = append(labels, v.Label)
+	}
+	if want := []string{"2010", "1990", "1980", "1970", "900"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("decades: got %v, want %v", order, want)
+	}
+	if labels[0] != "2010s" || labels[4] != "900s" {
+		t.Errorf("decade labels: got %v", labels)
+	}
+
+	values = topFacetValues("available", map[string]int{"false": 9, "true": 1}, map[string]bool{"false": true})
+	if len(values) != 2 || values[0] != (facetValue{Value: "true", Label: "available", Count: 1}) ||
+		values[1] != (facetValue{Value: "false", Label: "all copies out", Count: 9, Selected: true}) {
+		t.Errorf("available: got %+v", values)
+	}
+}
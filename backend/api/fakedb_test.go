@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bxb454/csds-395-lgbt-library-catalog/cas"
+	"github.com/bxb454/csds-395-lgbt-library-catalog/search"
+	"golang.org/x/time/rate"
+)
+
+// fakeQuery answers a query for handler tests with the columns and rows it would return
+type fakeQuery func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error)
+
+// a database/sql driver that hands every query to the fakeQuery registered under its dsn,
+// so a handler can run end to end without mysql
+type fakeDriver struct{}
+
+var fakeQueries sync.Map //dsn -> fakeQuery
+
+func init() {
+	sql.Register("fake", fakeDriver{})
+}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	q, ok := fakeQueries.Load(dsn)
+	if !ok {
+		return nil, errors.New("no fake queries for " + dsn)
+	}
+	return fakeConn{q.(fakeQuery)}, nil
+}
+
+type fakeConn struct{ query fakeQuery }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("fake: no prepare") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return fakeTx{}, nil }
+
+// a transaction is just the queries in it, there's nothing to commit or roll back
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+// ExecContext goes to the same fakeQuery, which returns no rows for it
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if _, _, err := c.query(query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	cols, rows, err := c.query(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{cols: cols, rows: rows}, nil
+}
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// newTestServer is a Server with all its routes over the fake database
+func newTestServer(t *testing.T, q fakeQuery) *Server {
+	t.Helper()
+	fakeQueries.Store(t.Name(), q)
+	db, err := sql.Open("fake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakeQueries.Delete(t.Name())
+	})
+	casClient, err := cas.NewClient("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		db:           db,
+		router:       http.NewServeMux(),
+		limiters:     make(map[string]*rate.Limiter),
+		rateInterval: time.Millisecond,
+		rateBurst:    100,
+		cas:          casClient,
+		index:        search.New(),
+	}
+	s.routes()
+	return s
+}
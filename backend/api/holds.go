@@ -0,0 +1,352 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	errHoldNotFound  = errors.New("hold not found")
+	errHoldClosed    = errors.New("hold is no longer active")
+	errDuplicateHold = errors.New("already on hold for this book")
+	errBookAvailable = errors.New("copies are available, check it out instead")
+	errAlreadyLoaned = errors.New("already checked out to this user")
+	errHoldsWaiting  = errors.New("other patrons are waiting for this book")
+)
+
+// FIFO by holdID, it's auto increment so it already follows the order holds were placed in.
+// position is only filled in for waiting holds, 1 means next in line.
+const holdColumns = `h.holdID, h.bookID, h.caseID, h.placedAt, h.status, h.readyAt, h.expiresAt,
+    CASE WHEN h.status = 'waiting' THEN 1 + (
+        SELECT COUNT(*) FROM holds q WHERE q.bookID = h.bookID AND q.status = 'waiting' AND q.holdID < h.holdID
+    ) END`
+
+func scanHold(row interface{ Scan(...any) error }) (hold, error) {
+	var h hold
+	err := row.Scan(&h.ID, &h.BookID, &h.CaseID, &h.PlacedAt, &h.Status, &h.ReadyAt, &h.ExpiresAt, &h.Position)
+	return h, err
+}
+
+func (s *Server) holdByID(ctx context.Context, id int) (hold, error) {
+	h, err := scanHold(s.db.QueryRowContext(ctx, `SELECT `+holdColumns+` FROM holds h WHERE h.holdID = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return hold{}, errHoldNotFound
+	}
+	return h, err
+}
+
+func (s *Server) queryHolds(ctx context.Context, where string, args ...interface{}) ([]hold, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+holdColumns+` FROM holds h WHERE `+where+` ORDER BY h.holdID`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []hold
+	for rows.Next() {
+		h, err := scanHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, h)
+	}
+	return result, rows.Err()
+}
+
+// GET/POST /books/{id}/holds
+// staff can see the whole queue, patrons get in line (staff can place one for a patron with "caseID")
+func (s *Server) handleBookHolds(w http.ResponseWriter, r *http.Request, id string) {
+	bookID, err := strconv.Atoi(id)
+	if err != nil {
+		http.Error(w, "invalid book id", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		holds, err := s.queryHolds(r.Context(), `h.bookID = ? AND h.status IN ('waiting', 'ready')`, bookID)
+		if err != nil {
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, holds)
+
+	case http.MethodPost:
+		type payload struct {
+			CaseID string `json:"caseID"`
+		}
+		var body payload
+		//the body is optional, an empty one means "put me in line"
+		if err := decodeJSON(r, &body); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		c := callerFrom(r.Context())
+		if body.CaseID == "" {
+			body.CaseID = c.CaseID
+		}
+		if !c.canActFor(body.CaseID) {
+			writeError(w, http.StatusForbidden, "cannot place a hold for another user")
+			return
+		}
+
+		h, err := s.placeHold(r.Context(), bookID, body.CaseID)
+		if err != nil {
+			writeHoldError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, h)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// GET /users/{caseID}/holds, active holds only
+func (s *Server) handleUserHolds(w http.ResponseWriter, r *http.Request, caseID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !callerFrom(r.Context()).canActFor(caseID) {
+		writeError(w, http.StatusForbidden, errNotYourRecord.Error())
+		return
+	}
+
+	holds, err := s.queryHolds(r.Context(), `h.caseID = ? AND h.status IN ('waiting', 'ready')`, caseID)
+	if err != nil {
+		http.Error(w, "query failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, holds)
+}
+
+// GET /holds/{id} and DELETE /holds/{id} to cancel
+func (s *Server) handleHoldByID() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/holds/"))
+		if err != nil {
+			http.Error(w, "invalid hold id", http.StatusBadRequest)
+			return
+		}
+		c := callerFrom(r.Context())
+
+		switch r.Method {
+		case http.MethodGet:
+			h, err := s.holdByID(r.Context(), id)
+			if err != nil {
+				writeHoldError(w, r, err)
+				return
+			}
+			if !c.canActFor(h.CaseID) {
+				writeError(w, http.StatusForbidden, errNotYourRecord.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, h)
+
+		case http.MethodDelete:
+			if err := s.cancelHold(r.Context(), c, id); err != nil {
+				writeHoldError(w, r, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// freeCopies is the in-transaction version of availableExpr and locks the books row while it's at it.
+// a ready hold belonging to forCaseID doesn't count against them since that copy is being kept for them.
+func freeCopies(ctx context.Context, tx *sql.Tx, bookID int, forCaseID string) (int, error) {
+	var free int
+	err := tx.QueryRowContext(ctx, `
+        SELECT books.copies
+            - (SELECT COUNT(*) FROM loan l WHERE l.bookID = books.bookID AND l.returnDate IS NULL)
+            - (SELECT COUNT(*) FROM holds h WHERE h.bookID = books.bookID AND h.status = 'ready' AND h.caseID <> ?)
+        FROM books WHERE bookID = ? FOR UPDATE`,
+		forCaseID, bookID,
+	).Scan(&free)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errBookNotFound
+	}
+	return free, err
+}
+
+func (s *Server) placeHold(ctx context.Context, bookID int, caseID string) (hold, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return hold{}, err
+	}
+	defer tx.Rollback()
+
+	var restricted bool
+	err = tx.QueryRowContext(ctx, `SELECT isRestricted FROM users WHERE caseID = ?`, caseID).Scan(&restricted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return hold{}, errUnknownUser
+	}
+	if err != nil {
+		return hold{}, err
+	}
+	if restricted {
+		return hold{}, errRestricted
+	}
+
+	free, err := freeCopies(ctx, tx, bookID, "")
+	if err != nil {
+		return hold{}, err
+	}
+	//a hold on a book that's on the shelf would never get promoted, nothing is coming back
+	if free > 0 {
+		return hold{}, errBookAvailable
+	}
+
+	var dupHold, dupLoan bool
+	err = tx.QueryRowContext(ctx, `
+        SELECT
+            EXISTS(SELECT 1 FROM holds WHERE bookID = ? AND caseID = ? AND status IN ('waiting', 'ready')),
+            EXISTS(SELECT 1 FROM loan WHERE bookID = ? AND caseID = ? AND returnDate IS NULL)`,
+		bookID, caseID, bookID, caseID,
+	).Scan(&dupHold, &dupLoan)
+	if err != nil {
+		return hold{}, err
+	}
+	if dupHold {
+		return hold{}, errDuplicateHold
+	}
+	if dupLoan {
+		return hold{}, errAlreadyLoaned
+	}
+
+	res, err := tx.ExecContext(ctx, `
+        INSERT INTO holds (bookID, caseID, placedAt, status)
+        VALUES (?, ?, NOW(), 'waiting')`, bookID, caseID,
+	)
+	if err != nil {
+		return hold{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return hold{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return hold{}, err
+	}
+	return s.holdByID(ctx, int(id))
+}
+
+func (s *Server) cancelHold(ctx context.Context, c caller, id int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var bookID int
+	var caseID, status string
+	err = tx.QueryRowContext(ctx, `
+        SELECT bookID, caseID, status FROM holds WHERE holdID = ? FOR UPDATE`, id,
+	).Scan(&bookID, &caseID, &status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errHoldNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !c.canActFor(caseID) {
+		return errNotYourRecord
+	}
+	if status != "waiting" && status != "ready" {
+		return errHoldClosed
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE holds SET status = 'cancelled' WHERE holdID = ?`, id); err != nil {
+		return err
+	}
+	//a cancelled ready hold frees up the copy that was being kept for it
+	if status == "ready" {
+		if err := s.promoteHolds(ctx, tx, bookID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// promoteHolds moves the oldest waiting holds on a book to ready, one per free copy.
+// called whenever a copy might have come free: returns, cancellations and expiry.
+func (s *Server) promoteHolds(ctx context.Context, tx *sql.Tx, bookID int) error {
+	free, err := freeCopies(ctx, tx, bookID, "")
+	if err != nil || free <= 0 {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+        UPDATE holds
+        SET status = 'ready', readyAt = NOW(), expiresAt = DATE_ADD(NOW(), INTERVAL ? DAY)
+        WHERE bookID = ? AND status = 'waiting'
+        ORDER BY holdID
+        LIMIT ?`,
+		s.loanPolicy.PickupDays, bookID, free,
+	)
+	return err
+}
+
+// expireHolds closes ready holds nobody picked up in time and hands their copies to the next in line.
+// also promotes anything that's waiting on a book with free copies, e.g. after staff bumped the copy count.
+func (s *Server) expireHolds(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+        UPDATE holds SET status = 'expired' WHERE status = 'ready' AND expiresAt < NOW()`)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("expired %d uncollected holds", n)
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT DISTINCT bookID FROM holds WHERE status = 'waiting'`)
+	if err != nil {
+		return err
+	}
+	var bookIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		bookIDs = append(bookIDs, id)
+	}
+	rows.Close()
+
+	for _, id := range bookIDs {
+		if err := s.promoteHolds(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func writeHoldError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errHoldNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, errHoldClosed), errors.Is(err, errDuplicateHold),
+		errors.Is(err, errBookAvailable), errors.Is(err, errAlreadyLoaned):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeLoanError(w, r, err)
+	}
+}
@@ -0,0 +1,441 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bxb454/csds-395-lgbt-library-catalog/marc"
+)
+
+const maxImportBytes = 10 << 20
+
+var errImportFormat = errors.New("invalid import file")
+
+// the columns import understands, keyed by the header names we accept for them
+var importColumns = map[string]string{
+	"title":     "title",
+	"isbn":      "isbn",
+	"authors":   "authors",
+	"author":    "authors",
+	"tags":      "tags",
+	"tag":       "tags",
+	"publisher": "publisher",
+	"edition":   "edition",
+	"copies":    "copies",
+	"pubdate":   "pubdate",
+}
+
+// ImportRow is what happened to one line of the csv. status is ok, error or duplicate,
+// duplicates are skipped without failing the import
+type ImportRow struct {
+	Row         int      `json:"row"` //line a csv record starts on (the header is line 1), record number in a marc file
+	Title       string   `json:"title"`
+	Status      string   `json:"status"`
+	Errors      []string `json:"errors,omitempty"`
+	DuplicateOf string   `json:"duplicateOf,omitempty"`
+	BookID      int      `json:"bookID,omitempty"` //only set once committed
+}
+
+type ImportReport struct {
+	DryRun         bool        `json:"dryRun"`
+	Committed      bool        `json:"committed"`
+	Books          int         `json:"books"`
+	NewAuthors     int         `json:"newAuthors"`
+	Errors         int         `json:"errors"`
+	Duplicates     int         `json:"duplicates"`
+	IgnoredColumns []string    `json:"ignoredColumns,omitempty"`
+	Rows           []ImportRow `json:"rows"`
+}
+
+// a row that passed validation, ready to insert
+type importBook struct {
+	isbn      *string
+	title     string
+	pubdate   *string
+	publisher *string
+	edition   *string
+	copies    int
+	authors   []author
+	tags      []string
+	//only marc records have these, see resolveSubject
+	subjects []marc.Subject
+}
+
+// ImportBooks reads a csv of books (see the README for the columns) and adds them with their authors
+// and tags in one transaction. every row is validated first and nothing is written if any row has an
+// error. a dry run goes through the exact same steps and then rolls back, so its report is what a real run would do.
+func (s *Server) ImportBooks(ctx context.Context, file io.Reader, dryRun bool) (ImportReport, error) {
+	report := ImportReport{DryRun: dryRun}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return report, fmt.Errorf("%w: could not read the header row: %w", errImportFormat, err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		if i == 0 {
+			//excel puts a byte order mark in front of utf-8 csvs
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		key, ok := importColumns[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			report.IgnoredColumns = append(report.IgnoredColumns, name)
+			continue
+		}
+		columns[key] = i
+	}
+	if _, ok := columns["title"]; !ok {
+		return report, fmt.Errorf("%w: there's no title column", errImportFormat)
+	}
+
+	var books []*importBook
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("%w: %w", errImportFormat, err)
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		//where the record starts in the file, a quoted field can span lines and blank lines are skipped
+		line, _ := reader.FieldPos(0)
+		book, errs := parseImportRow(field)
+		row := ImportRow{Row: line, Title: field("title"), Status: "ok", Errors: errs}
+		if len(errs) > 0 {
+			row.Status = "error"
+			report.Errors++
+			book = nil
+		}
+		report.Rows = append(report.Rows, row)
+		books = append(books, book)
+	}
+	if len(report.Rows) == 0 {
+		return report, fmt.Errorf("%w: there are no rows after the header", errImportFormat)
+	}
+	return s.runImport(ctx, report, books)
+}
+
+// runImport does the writing half of an import. books lines up with report.Rows, nil for rows that failed validation
+func (s *Server) runImport(ctx context.Context, report ImportReport, books []*importBook) (ImportReport, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	//earlier rows of this file, so a book listed twice is only added once
+	seen := make(map[string]int)
+	authorIDs := make(map[string]int)
+	for i, book := range books {
+		if book == nil {
+			continue
+		}
+		row := &report.Rows[i]
+
+		key := importKey(book)
+		if first, ok := seen[key]; ok {
+			row.Status, row.DuplicateOf = "duplicate", fmt.Sprintf("row %d", first)
+			report.Duplicates++
+			continue
+		}
+		seen[key] = row.Row
+		existing, err := findDuplicateBook(ctx, tx, book)
+		if err != nil {
+			return report, err
+		}
+		if existing != 0 {
+			row.Status, row.DuplicateOf = "duplicate", fmt.Sprintf("book %d", existing)
+			report.Duplicates++
+			continue
+		}
+
+		res, err := tx.ExecContext(ctx, `
+            INSERT INTO books (isbn, title, pubdate, publisher, edition, copies, loanMetrics)
+            VALUES (?, ?, ?, ?, ?, ?, 0)`,
+			book.isbn, book.title, book.pubdate, book.publisher, book.edition, book.copies,
+		)
+		if err != nil {
+			return report, err
+		}
+		bookID, _ := res.LastInsertId()
+		row.BookID = int(bookID)
+		report.Books++
+
+		for position, a := range book.authors {
+			name := strings.ToLower(a.LName + "\x00" + nullable(a.FName))
+			authID, ok := authorIDs[name]
+			if !ok {
+				var created bool
+				if authID, created, err = findOrCreateAuthor(ctx, tx, a); err != nil {
+					return report, err
+				}
+				if created {
+					report.NewAuthors++
+				}
+				authorIDs[name] = authID
+			}
+			if _, err := tx.ExecContext(ctx, `
+                INSERT IGNORE INTO bookAuthor (bookID, authID, position) VALUES (?, ?, ?)`, bookID, authID, position,
+			); err != nil {
+				return report, err
+			}
+		}
+		tags := book.tags
+		for _, sub := range book.subjects {
+			uri, err := resolveSubject(ctx, tx, sub)
+			if err != nil {
+				return report, err
+			}
+			if uri != "" {
+				if _, err := tx.ExecContext(ctx, `INSERT IGNORE INTO bookSubjects (bookID, uri) VALUES (?, ?)`, bookID, uri); err != nil {
+					return report, err
+				}
+				continue
+			}
+			//a heading we don't have (LCSH etc) is still worth keeping, as a tag
+			if tag, err := normalizeTag(slashFree(sub.Label)); err == nil {
+				tags = append(tags, tag)
+			}
+		}
+		for _, tag := range tags {
+			if _, err := tx.ExecContext(ctx, `INSERT IGNORE INTO booktags (bookID, tag) VALUES (?, ?)`, bookID, tag); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	if report.DryRun || report.Errors > 0 {
+		for i := range report.Rows {
+			report.Rows[i].BookID = 0
+		}
+		return report, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return report, err
+	}
+	report.Committed = true
+	return report, nil
+}
+
+// parseImportRow validates one csv line, collecting every problem instead of stopping at the first.
+// authors and tags are separated by semicolons, authors are written "Last, First" (or just one name)
+func parseImportRow(field func(string) string) (*importBook, []string) {
+	var errs []string
+	book := &importBook{title: field("title"), copies: 1}
+
+	switch {
+	case book.title == "":
+		errs = append(errs, "title is required")
+	case utf8.RuneCountInString(book.title) > 255:
+		errs = append(errs, "title can be at most 255 characters")
+	}
+	if raw := field("isbn"); raw != "" {
+		if isbn, err := normalizeISBN(raw); err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			book.isbn = &isbn
+		}
+	}
+	if raw := field("pubdate"); raw != "" {
+		if _, err := time.Parse("2006-01-02", raw); err != nil {
+			errs = append(errs, "pubdate must look like 2006-01-02")
+		} else {
+			book.pubdate = &raw
+		}
+	}
+	for _, f := range []struct {
+		name string
+		dst  **string
+	}{{"publisher", &book.publisher}, {"edition", &book.edition}} {
+		raw := field(f.name)
+		if raw == "" {
+			continue
+		}
+		if utf8.RuneCountInString(raw) > 64 {
+			errs = append(errs, f.name+" can be at most 64 characters")
+			continue
+		}
+		*f.dst = &raw
+	}
+	if raw := field("copies"); raw != "" {
+		copies, err := strconv.Atoi(raw)
+		if err != nil || copies <= 0 {
+			errs = append(errs, "copies must be a positive whole number")
+		}
+		book.copies = copies
+	}
+
+	for _, raw := range strings.Split(field("authors"), ";") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		var a author
+		lname, fname, hasFirst := strings.Cut(raw, ",")
+		a.LName = strings.TrimSpace(lname)
+		if fname = strings.TrimSpace(fname); hasFirst && fname != "" {
+			a.FName = &fname
+		}
+		if a.LName == "" {
+			errs = append(errs, fmt.Sprintf("author %q has no last name", raw))
+			continue
+		}
+		if utf8.RuneCountInString(a.LName) > 64 || utf8.RuneCountInString(nullable(a.FName)) > 64 {
+			errs = append(errs, fmt.Sprintf("author %q: names can be at most 64 characters", raw))
+			continue
+		}
+		book.authors = append(book.authors, a)
+	}
+	for _, raw := range strings.Split(field("tags"), ";") {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		tag, err := normalizeTag(raw)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		book.tags = append(book.tags, tag)
+	}
+	return book, errs
+}
+
+// importKey is what makes two rows the same book: the isbn, or without one the title and edition
+func importKey(b *importBook) string {
+	if b.isbn != nil {
+		return "isbn:" + *b.isbn
+	}
+	return "title:" + strings.ToLower(b.title) + "\x00" + strings.ToLower(nullable(b.edition))
+}
+
+// findDuplicateBook returns the id of a book already in the catalog that matches by importKey, 0 if there's none
+func findDuplicateBook(ctx context.Context, tx *sql.Tx, b *importBook) (int, error) {
+	var bookID int
+	var err error
+	if b.isbn != nil {
+		err = tx.QueryRowContext(ctx, `SELECT bookID FROM books WHERE isbn = ? ORDER BY bookID LIMIT 1`, *b.isbn).Scan(&bookID)
+	} else {
+		err = tx.QueryRowContext(ctx, `
+            SELECT bookID FROM books WHERE title = ? AND edition <=> ? ORDER BY bookID LIMIT 1`, b.title, b.edition,
+		).Scan(&bookID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return bookID, err
+}
+
+// findOrCreateAuthor reuses an author with exactly this preferred name, aliases don't count
+func findOrCreateAuthor(ctx context.Context, tx *sql.Tx, a author) (int, bool, error) {
+	var authID int
+	err := tx.QueryRowContext(ctx, `
+        SELECT authID FROM authors WHERE lname = ? AND fname <=> ? ORDER BY authID LIMIT 1`, a.LName, a.FName,
+	).Scan(&authID)
+	if err == nil {
+		return authID, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, err
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO authors (lname, fname) VALUES (?, ?)`, a.LName, a.FName)
+	if err != nil {
+		return 0, false, err
+	}
+	id, _ := res.LastInsertId()
+	return int(id), true, nil
+}
+
+func nullable(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// POST /books/import?dryRun=true with the file as the raw body or as a "file" field of a multipart form.
+// it's a csv unless format=marc is given or the Content-Type says marc/xml, marc can be binary or MARCXML.
+// 200 with the report for a dry run, 201 once committed, 422 with the report if any row has an error
+func (s *Server) handleBookImport() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+		var file io.Reader = r.Body
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			f, _, err := r.FormFile("file")
+			if err != nil {
+				http.Error(w, "missing file", http.StatusBadRequest)
+				return
+			}
+			defer f.Close()
+			file = f
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "csv"
+			if ct := r.Header.Get("Content-Type"); strings.Contains(ct, "marc") || strings.Contains(ct, "xml") {
+				format = "marc"
+			}
+		}
+
+		var report ImportReport
+		var err error
+		switch format {
+		case "csv":
+			report, err = s.ImportBooks(r.Context(), file, dryRun)
+		case "marc", "marcxml":
+			var records []marc.Record
+			if records, err = marc.Parse(file); err != nil {
+				err = fmt.Errorf("%w: %w", errImportFormat, err)
+				break
+			}
+			report, err = s.ImportMARC(r.Context(), records, dryRun)
+		default:
+			http.Error(w, "format must be csv or marc", http.StatusBadRequest)
+			return
+		}
+		var tooBig *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooBig):
+			http.Error(w, fmt.Sprintf("import file can be at most %d MB", maxImportBytes>>20), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, errImportFormat):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case err != nil:
+			log.Printf("book import: %v", err)
+			http.Error(w, "import failed", http.StatusInternalServerError)
+		case report.Committed:
+			writeJSON(w, http.StatusCreated, report)
+		case !dryRun && report.Errors > 0:
+			writeJSON(w, http.StatusUnprocessableEntity, report)
+		default:
+			writeJSON(w, http.StatusOK, report)
+		}
+	})
+}
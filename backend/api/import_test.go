@@ -0,0 +1,93 @@
+package api
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseImportRow(t *testing.T) {
+	tests := []struct {
+		name string
+		row  map[string]string
+		want *importBook
+	}{
+		{
+			"title only",
+			map[string]string{"title": "Zami"},
+			&importBook{title: "Zami", copies: 1},
+		},
+		{
+			"every column",
+			map[string]string{
+				"title": "Stone Butch Blues", "isbn": "1-55583-853-7", "pubdate": "1993-03-01",
+				"publisher": "Firebrand", "edition": "2nd", "copies": "3",
+				"authors": "Feinberg, Leslie; Kai ; Lorde,", "tags": "Fiction; trans  history;;",
+			},
+			&importBook{
+				title: "Stone Butch Blues", isbn: ptr("9781555838539"), pubdate: ptr("1993-03-01"),
+				publisher: ptr("Firebrand"), edition: ptr("2nd"), copies: 3,
+				authors: []author{{LName: "Feinberg", FName: ptr("Leslie")}, {LName: "Kai"}, {LName: "Lorde"}},
+				tags:    []string{"fiction", "trans history"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errs := parseImportRow(func(name string) string { return tt.row[name] })
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors %q", errs)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseImportRowErrors(t *testing.T) {
+	long := strings.Repeat("x", 65)
+	tests := []struct {
+		name string
+		row  map[string]string
+		want []string
+	}{
+		{"no title", map[string]string{"isbn": "9780306406157"}, []string{"title is required"}},
+		{"long title", map[string]string{"title": strings.Repeat("é", 256)}, []string{"title can be at most 255 characters"}},
+		{"bad isbn", map[string]string{"title": "t", "isbn": "0306406153"}, []string{"invalid isbn: ISBN-10 check digit should be 2, not 3"}},
+		{"bad pubdate", map[string]string{"title": "t", "pubdate": "1993-02-30"}, []string{"pubdate must look like 2006-01-02"}},
+		{"year only pubdate", map[string]string{"title": "t", "pubdate": "1993"}, []string{"pubdate must look like 2006-01-02"}},
+		{
+			"long publisher and edition",
+			map[string]string{"title": "t", "publisher": long, "edition": long},
+			[]string{"publisher can be at most 64 characters", "edition can be at most 64 characters"},
+		},
+		{"zero copies", map[string]string{"title": "t", "copies": "0"}, []string{"copies must be a positive whole number"}},
+		{"copies not a number", map[string]string{"title": "t", "copies": "two"}, []string{"copies must be a positive whole number"}},
+		{"author without last name", map[string]string{"title": "t", "authors": "Lorde, Audre; , Leslie"}, []string{`author ", Leslie" has no last name`}},
+		{
+			"long author name",
+			map[string]string{"title": "t", "authors": "Lorde, " + long},
+			[]string{`author "Lorde, ` + long + `": names can be at most 64 characters`},
+		},
+		{"bad tag", map[string]string{"title": "t", "tags": "poetry; a/b"}, []string{`invalid tag: tags can't contain "/", use "-" instead`}},
+		{
+			"every problem is reported",
+			map[string]string{"isbn": "12", "copies": "-1", "tags": "a/b"},
+			[]string{
+				"title is required",
+				"invalid isbn: an ISBN has 10 or 13 digits, this one has 2",
+				"copies must be a positive whole number",
+				`invalid tag: tags can't contain "/", use "-" instead`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := parseImportRow(func(name string) string { return tt.row[name] })
+			if !reflect.DeepEqual(errs, tt.want) {
+				t.Errorf("got %q, want %q", errs, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+var errInvalidISBN = errors.New("invalid isbn")
+
+// normalizeISBN checks an ISBN-10 or ISBN-13, hyphens and spaces allowed, and returns it as
+// a bare ISBN-13, the form books.isbn is stored and searched in. the error says what's wrong with it.
+func normalizeISBN(raw string) (string, error) {
+	digits := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(raw)))
+	for i, c := range digits {
+		//X is only a check digit, and only in an ISBN-10
+		if (c < '0' || c > '9') && !(c == 'X' && i == 9 && len(digits) == 10) {
+			return "", fmt.Errorf("%w: unexpected character %q", errInvalidISBN, c)
+		}
+	}
+
+	switch len(digits) {
+	case 10:
+		sum := 0
+		for i := 0; i < 9; i++ {
+			sum += int(digits[i]-'0') * (10 - i)
+		}
+		check := (11 - sum%11) % 11
+		want := byte('0' + check)
+		if check == 10 {
+			want = 'X'
+		}
+		if digits[9] != want {
+			return "", fmt.Errorf("%w: ISBN-10 check digit should be %c, not %c", errInvalidISBN, want, digits[9])
+		}
+		isbn := "978" + digits[:9]
+		return isbn + string(isbn13Check(isbn)), nil
+
+	case 13:
+		if !strings.HasPrefix(digits, "978") && !strings.HasPrefix(digits, "979") {
+			return "", fmt.Errorf("%w: ISBN-13 must start with 978 or 979", errInvalidISBN)
+		}
+		if want := isbn13Check(digits[:12]); digits[12] != want {
+			return "", fmt.Errorf("%w: ISBN-13 check digit should be %c, not %c", errInvalidISBN, want, digits[12])
+		}
+		return digits, nil
+	}
+	return "", fmt.Errorf("%w: an ISBN has 10 or 13 digits, this one has %d", errInvalidISBN, len(digits))
+}
+
+// isbn13Check computes the check digit for the first 12 digits of an ISBN-13
+func isbn13Check(first12 string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(first12[i]-'0') * weight
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// NormalizeISBNs rewrites every stored isbn as a bare ISBN-13. ones that don't pass the checksum
+// are left alone and logged so someone can fix them by hand. run it once on a catalog that predates normalizeISBN.
+func (s *Server) NormalizeISBNs(ctx context.Context) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT bookID, isbn FROM books WHERE isbn IS NOT NULL`)
+	if err != nil {
+		return 0, err
+	}
+	type row struct {
+		bookID int
+		isbn   string
+	}
+	var stale []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.bookID, &r.isbn); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		isbn, err := normalizeISBN(r.isbn)
+		if err != nil {
+			log.Printf("book %d: %q: %v", r.bookID, r.isbn, err)
+			continue
+		}
+		if isbn != r.isbn {
+			stale = append(stale, row{bookID: r.bookID, isbn: isbn})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, r := range stale {
+		if _, err := tx.ExecContext(ctx, `UPDATE books SET isbn = ? WHERE bookID = ?`, r.isbn, r.bookID); err != nil {
+			return 0, err
+		}
+	}
+	return len(stale), tx.Commit()
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"9780306406157", "9780306406157"},
+		{"978-0-306-40615-7", "9780306406157"},
+		{" 978 0 306 40615 7 ", "9780306406157"},
+		{"0306406152", "9780306406157"},
+		{"0-306-40615-2", "9780306406157"},
+		{"1-55583-853-7", "9781555838539"},
+		//X is 10 as the ISBN-10 check digit, and the ISBN-13 gets its own
+		{"080442957X", "9780804429573"},
+		{"0-8044-2957-x", "9780804429573"},
+		{"0 439 42089 X", "9780439420891"},
+		{"979-10-00000-00-8", "9791000000008"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeISBN(tt.raw)
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeISBN(%q) = %q, %v, want %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeISBNInvalid(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"", "has 0"},
+		{"---", "has 0"},
+		{"030640615", "has 9"},
+		{"97803064061570", "has 14"},
+		{"0306406153", "check digit should be 2, not 3"},
+		{"0306406150", "check digit should be 2, not 0"},
+		{"0804429570", "check digit should be X, not 0"},
+		{"9780306406158", "check digit should be 7, not 8"},
+		{"9770306406157", "must start with 978 or 979"},
+		//X only counts as the last digit of an ISBN-10
+		{"X306406152", `unexpected character 'X'`},
+		{"978030640615X", `unexpected character 'X'`},
+		{"0-306-40615-2.", `unexpected character '.'`},
+		{"isbn 0306406152", `unexpected character 'I'`},
+		{"０306406152", `unexpected character '０'`},
+	}
+	for _, tt := range tests {
+		_, err := normalizeISBN(tt.raw)
+		if !errors.Is(err, errInvalidISBN) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("normalizeISBN(%q) = %v, want an error saying %q", tt.raw, err, tt.want)
+		}
+	}
+}
+
+func TestISBN13Check(t *testing.T) {
+	for first12, want := range map[string]byte{
+		"978030640615": '7',
+		"978155583853": '9',
+		"979100000000": '8',
+		"978000000000": '2',
+		//a sum that's already a multiple of 10 checks with 0, not 10
+		"978000000004": '0',
+	} {
+		if got := isbn13Check(first12); got != want {
+			t.Errorf("isbn13Check(%q) = %c, want %c", first12, got, want)
+		}
+	}
+}
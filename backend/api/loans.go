@@ -0,0 +1,306 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// loanPolicy decides due dates and renewals so clients don't get to pick their own
+type loanPolicy struct {
+	LoanDays    int
+	RenewalDays int
+	MaxRenewals int
+	//how long a copy sits on the hold shelf before the hold expires
+	PickupDays int
+}
+
+var (
+	errNoCopies      = errors.New("no copies available")
+	errRestricted    = errors.New("account is restricted")
+	errUnknownUser   = errors.New("unknown user")
+	errLoanReturned  = errors.New("loan already returned")
+	errRenewalLimit  = errors.New("renewal limit reached")
+	errLoanNotFound  = errors.New("loan not found")
+	errBookNotFound  = errors.New("book not found")
+	errNotYourRecord = errors.New("cannot act for another user")
+)
+
+const loanColumns = `loanID, bookID, caseID, loanDate, dueDate, returnDate, numRenewals`
+
+func scanLoan(row interface{ Scan(...any) error }) (loan, error) {
+	var l loan
+	err := row.Scan(&l.ID, &l.BookID, &l.CaseID, &l.LoanDate, &l.DueDate, &l.ReturnDate, &l.NumRenewals)
+	return l, err
+}
+
+func (s *Server) loanByID(ctx context.Context, id int) (loan, error) {
+	l, err := scanLoan(s.db.QueryRowContext(ctx, `SELECT `+loanColumns+` FROM loan WHERE loanID = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return loan{}, errLoanNotFound
+	}
+	return l, err
+}
+
+// handles /loans/checkout, /loans/{id}, /loans/{id}/return and /loans/{id}/renew
+func (s *Server) handleLoanByID() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, "/loans/")
+		if rest == "checkout" {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			s.checkOut(w, r)
+			return
+		}
+
+		idStr, action, _ := strings.Cut(rest, "/")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "invalid loan id", http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			l, err := s.loanByID(r.Context(), id)
+			if err != nil {
+				writeLoanError(w, r, err)
+				return
+			}
+			if l.CaseID == nil || !callerFrom(r.Context()).canActFor(*l.CaseID) {
+				writeError(w, http.StatusForbidden, errNotYourRecord.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, l)
+
+		case action == "return" && r.Method == http.MethodPost:
+			l, err := s.returnLoan(r.Context(), id)
+			if err != nil {
+				writeLoanError(w, r, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, l)
+
+		case action == "renew" && r.Method == http.MethodPost:
+			l, err := s.renewLoan(r.Context(), callerFrom(r.Context()), id)
+			if err != nil {
+				writeLoanError(w, r, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, l)
+
+		case action == "" || action == "return" || action == "renew":
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// POST /loans/checkout {"bookID": 1001, "caseID": "abc123"}
+// caseID defaults to the caller, staff can check out on behalf of a patron at the desk
+func (s *Server) checkOut(w http.ResponseWriter, r *http.Request) {
+	type payload struct {
+		BookID int    `json:"bookID"`
+		CaseID string `json:"caseID"`
+	}
+	var body payload
+	if err := decodeJSON(r, &body); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	c := callerFrom(r.Context())
+	if body.CaseID == "" {
+		body.CaseID = c.CaseID
+	}
+	if body.BookID <= 0 || body.CaseID == "" {
+		http.Error(w, "missing required fields", http.StatusBadRequest)
+		return
+	}
+	if !c.canActFor(body.CaseID) {
+		writeError(w, http.StatusForbidden, "cannot check out for another user")
+		return
+	}
+
+	l, err := s.checkOutBook(r.Context(), body.BookID, body.CaseID)
+	if err != nil {
+		writeLoanError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, l)
+}
+
+// checkOutBook is the api version of the checkOutLoan procedure. the books row is locked for the
+// whole transaction so two people can't both walk off with the last copy.
+func (s *Server) checkOutBook(ctx context.Context, bookID int, caseID string) (loan, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return loan{}, err
+	}
+	defer tx.Rollback()
+
+	//authorize only checks the caller, staff could be checking out to a restricted patron
+	var restricted bool
+	err = tx.QueryRowContext(ctx, `SELECT isRestricted FROM users WHERE caseID = ?`, caseID).Scan(&restricted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return loan{}, errUnknownUser
+	}
+	if err != nil {
+		return loan{}, err
+	}
+	if restricted {
+		return loan{}, errRestricted
+	}
+
+	//copies being kept on the hold shelf for someone else don't count, the borrower's own ready hold does
+	free, err := freeCopies(ctx, tx, bookID, caseID)
+	if err != nil {
+		return loan{}, err
+	}
+	if free <= 0 {
+		return loan{}, errNoCopies
+	}
+
+	//dates come from the db clock like the stored procedures do
+	res, err := tx.ExecContext(ctx, `
+        INSERT INTO loan (bookID, caseID, loanDate, dueDate, numRenewals)
+        VALUES (?, ?, CURDATE(), DATE_ADD(CURDATE(), INTERVAL ? DAY), 0)`,
+		bookID, caseID, s.loanPolicy.LoanDays,
+	)
+	if err != nil {
+		return loan{}, err
+	}
+	loanID, err := res.LastInsertId()
+	if err != nil {
+		return loan{}, err
+	}
+
+	//loan metrics go up by 1 every time it's checked out. setting updatedAt to itself keeps
+	//ON UPDATE from bumping it, a checkout isn't a metadata change (see oai.go)
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE books SET loanMetrics = loanMetrics + 1, updatedAt = updatedAt WHERE bookID = ?`, bookID,
+	); err != nil {
+		return loan{}, err
+	}
+
+	//picking the book up is what fulfils a hold
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE holds SET status = 'fulfilled'
+        WHERE bookID = ? AND caseID = ? AND status IN ('waiting', 'ready')`, bookID, caseID,
+	); err != nil {
+		return loan{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return loan{}, err
+	}
+	return s.loanByID(ctx, int(loanID))
+}
+
+func (s *Server) returnLoan(ctx context.Context, id int) (loan, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return loan{}, err
+	}
+	defer tx.Rollback()
+
+	l, err := scanLoan(tx.QueryRowContext(ctx, `SELECT `+loanColumns+` FROM loan WHERE loanID = ? FOR UPDATE`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return loan{}, errLoanNotFound
+	}
+	if err != nil {
+		return loan{}, err
+	}
+	if l.ReturnDate != nil {
+		return loan{}, errLoanReturned
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE loan SET returnDate = CURDATE() WHERE loanID = ?`, id); err != nil {
+		return loan{}, err
+	}
+	//the copy that just came back goes to whoever is first in line
+	if err := s.promoteHolds(ctx, tx, l.BookID); err != nil {
+		return loan{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return loan{}, err
+	}
+	return s.loanByID(ctx, id)
+}
+
+// renewLoan pushes the due date out by the renewal period, counted from today if the loan is already overdue
+func (s *Server) renewLoan(ctx context.Context, c caller, id int) (loan, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return loan{}, err
+	}
+	defer tx.Rollback()
+
+	l, err := scanLoan(tx.QueryRowContext(ctx, `SELECT `+loanColumns+` FROM loan WHERE loanID = ? FOR UPDATE`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return loan{}, errLoanNotFound
+	}
+	if err != nil {
+		return loan{}, err
+	}
+	if l.CaseID == nil || !c.canActFor(*l.CaseID) {
+		return loan{}, errNotYourRecord
+	}
+	if l.ReturnDate != nil {
+		return loan{}, errLoanReturned
+	}
+	if l.NumRenewals >= s.loanPolicy.MaxRenewals {
+		return loan{}, errRenewalLimit
+	}
+	//no renewing a book other people are in line for
+	var waiting bool
+	err = tx.QueryRowContext(ctx, `
+        SELECT EXISTS(SELECT 1 FROM holds WHERE bookID = ? AND status = 'waiting')`, l.BookID,
+	).Scan(&waiting)
+	if err != nil {
+		return loan{}, err
+	}
+	if waiting {
+		return loan{}, errHoldsWaiting
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE loan
+        SET dueDate = DATE_ADD(GREATEST(dueDate, CURDATE()), INTERVAL ? DAY), numRenewals = numRenewals + 1
+        WHERE loanID = ?`,
+		s.loanPolicy.RenewalDays, id,
+	); err != nil {
+		return loan{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return loan{}, err
+	}
+	return s.loanByID(ctx, id)
+}
+
+// maps the loan workflow errors onto status codes, anything unexpected is a 500
+func writeLoanError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errLoanNotFound), errors.Is(err, errBookNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, errUnknownUser):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errRestricted), errors.Is(err, errNotYourRecord):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, errNoCopies), errors.Is(err, errLoanReturned), errors.Is(err, errRenewalLimit),
+		errors.Is(err, errHoldsWaiting):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("loan error: %v", err)
+		http.Error(w, "loan update failed", http.StatusInternalServerError)
+	}
+}
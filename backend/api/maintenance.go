@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// runMaintenance runs the background jobs once at startup and then every maintenanceInterval until stop is closed
+func (s *Server) runMaintenance(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.maintenanceInterval)
+	defer ticker.Stop()
+	for {
+		s.maintain()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) maintain() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := s.expireHolds(ctx); err != nil {
+		log.Printf("hold expiry failed: %v", err)
+	}
+	if n, err := s.anonymizeHistory(ctx); err != nil {
+		log.Printf("loan anonymization failed: %v", err)
+	} else if n > 0 {
+		log.Printf("anonymized %d returned loans", n)
+	}
+	//syncs only look at updatedAt, this catches whatever doesn't touch it (subject relations etc)
+	if err := s.rebuildIndex(ctx); err != nil {
+		log.Printf("search index rebuild failed: %v", err)
+	}
+}
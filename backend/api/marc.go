@@ -0,0 +1,201 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bxb454/csds-395-lgbt-library-catalog/marc"
+)
+
+const exportPageSize = 500
+
+// ImportMARC adds parsed MARC records the same way ImportBooks adds csv rows, with the same
+// validation, duplicate checks and dry run. 650 headings are linked to a subject when the catalog
+// has it (by uri, or by label for Homosaurus headings) and kept as tags otherwise, 653 terms become tags.
+func (s *Server) ImportMARC(ctx context.Context, records []marc.Record, dryRun bool) (ImportReport, error) {
+	report := ImportReport{DryRun: dryRun}
+	if len(records) == 0 {
+		return report, fmt.Errorf("%w: there are no records in the file", errImportFormat)
+	}
+
+	var books []*importBook
+	for i, rec := range records {
+		mb := marc.ToBook(rec)
+		fields := map[string]string{
+			"title":     mb.Title,
+			"publisher": mb.Publisher,
+			"edition":   mb.Edition,
+			"tags":      strings.Join(mb.Tags, ";"),
+		}
+		//records often list several isbns (hardcover, paperback...), keep the first one that checks out
+		for _, isbn := range mb.ISBNs {
+			if fields["isbn"] == "" {
+				fields["isbn"] = isbn //so a bad one still gets reported if none are valid
+			}
+			if _, err := normalizeISBN(isbn); err == nil {
+				fields["isbn"] = isbn
+				break
+			}
+		}
+		if mb.Year != "" {
+			fields["pubdate"] = mb.Year + "-01-01"
+		}
+		var authors []string
+		for _, n := range mb.Authors {
+			name := n.LName
+			if n.FName != "" {
+				name += ", " + n.FName
+			}
+			authors = append(authors, name)
+		}
+		fields["authors"] = strings.Join(authors, ";")
+
+		book, errs := parseImportRow(func(name string) string { return fields[name] })
+		row := ImportRow{Row: i + 1, Title: mb.Title, Status: "ok", Errors: errs}
+		if len(errs) > 0 {
+			row.Status = "error"
+			report.Errors++
+			book = nil
+		} else {
+			book.subjects = mb.Subjects
+		}
+		report.Rows = append(report.Rows, row)
+		books = append(books, book)
+	}
+	return s.runImport(ctx, report, books)
+}
+
+// resolveSubject finds the catalog's subject for a 650 heading, "" if we don't have it.
+// only Homosaurus headings (or ones without a source) are matched by label, an LCSH heading
+// that happens to share a label isn't necessarily the same concept
+func resolveSubject(ctx context.Context, tx *sql.Tx, sub marc.Subject) (string, error) {
+	var uri string
+	if sub.URI != "" {
+		err := tx.QueryRowContext(ctx, `SELECT uri FROM subjects WHERE uri = ?`, sub.URI).Scan(&uri)
+		if err == nil || !errors.Is(err, sql.ErrNoRows) {
+			return uri, err
+		}
+	}
+	if sub.Label == "" || (sub.Source != "" && sub.Source != marc.HomosaurusSource) {
+		return "", nil
+	}
+	err := tx.QueryRowContext(ctx, `
+        SELECT uri FROM subjects WHERE prefLabel = ?
+        UNION
+        SELECT uri FROM subjectAltLabels WHERE label = ?
+        LIMIT 1`, sub.Label, sub.Label,
+	).Scan(&uri)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return uri, err
+}
+
+// toMARC maps a book from queryBooksWithFilters onto a record
+func toMARC(b book) marc.Record {
+	mb := marc.Book{ID: b.ID, Title: b.Title, Tags: b.Tags}
+	if b.ISBN != nil {
+		mb.ISBNs = []string{*b.ISBN}
+	}
+	mb.Edition, mb.Publisher = nullable(b.Edition), nullable(b.Publisher)
+	if b.PubDate != nil && len(*b.PubDate) >= 4 {
+		mb.Year = (*b.PubDate)[:4]
+	}
+	for _, a := range b.Authors {
+		mb.Authors = append(mb.Authors, marc.Name{LName: a.LName, FName: nullable(a.FName)})
+	}
+	for _, sub := range b.Subjects {
+		mb.Subjects = append(mb.Subjects, marc.Subject{URI: sub.URI, Label: sub.PrefLabel, Source: marc.HomosaurusSource})
+	}
+	return marc.FromBook(mb)
+}
+
+func writeMARCXML(w http.ResponseWriter, records []marc.Record) {
+	var buf bytes.Buffer
+	if err := marc.WriteXML(&buf, records); err != nil {
+		log.Printf("marcxml: %v", err)
+		http.Error(w, "export failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/marcxml+xml; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// GET /books/{id}/marc is one book as MARCXML
+func (s *Server) handleBookMARC(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	bookID, err := strconv.Atoi(id)
+	if err != nil {
+		http.Error(w, "invalid book id", http.StatusBadRequest)
+		return
+	}
+	books, _, err := s.queryBooksWithFilters(r.Context(), BookFilters{IDs: []int{bookID}}, PaginationParams{Limit: 1})
+	if err != nil {
+		http.Error(w, "query failed", http.StatusInternalServerError)
+		return
+	}
+	if len(books) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	writeMARCXML(w, []marc.Record{toMARC(books[0])})
+}
+
+// GET /books/export?format=marcxml takes the same filters as GET /books plus tag= and authorID=,
+// and returns every matching book in one MARCXML collection, no pagination
+func (s *Server) handleBookExport() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if format := r.URL.Query().Get("format"); format != "" && format != "marcxml" {
+			http.Error(w, "format must be marcxml", http.StatusBadRequest)
+			return
+		}
+		filters := parseBookFilters(r)
+		if raw := r.URL.Query().Get("tag"); raw != "" {
+			tag, err := normalizeTag(raw)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			filters.Tag = tag
+		}
+		if raw := r.URL.Query().Get("authorID"); raw != "" {
+			authID, err := strconv.Atoi(raw)
+			if err != nil {
+				http.Error(w, "invalid author id", http.StatusBadRequest)
+				return
+			}
+			filters.AuthorID = authID
+		}
+
+		var records []marc.Record
+		for page := (PaginationParams{Limit: exportPageSize}); ; page.Offset += exportPageSize {
+			books, total, err := s.queryBooksWithFilters(r.Context(), filters, page)
+			if err != nil {
+				http.Error(w, "query failed", http.StatusInternalServerError)
+				return
+			}
+			for _, b := range books {
+				records = append(records, toMARC(b))
+			}
+			if page.Offset+exportPageSize >= total {
+				break
+			}
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="catalog.xml"`)
+		writeMARCXML(w, records)
+	})
+}
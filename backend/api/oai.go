@@ -0,0 +1,564 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OAI-PMH 2.0 data provider over the books table, for the university's discovery layer.
+// records are books, oai_dc is the only format and there are no sets. datestamps are books.updatedAt
+// (see oaipmh.sql), deleted books are reported from deletedBooks.
+const (
+	oaiNS         = "http://www.openarchives.org/OAI/2.0/"
+	oaiSchema     = "http://www.openarchives.org/OAI/2.0/ http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd"
+	oaiDCNS       = "http://www.openarchives.org/OAI/2.0/oai_dc/"
+	oaiDCSchema   = "http://www.openarchives.org/OAI/2.0/oai_dc.xsd"
+	dcElementsNS  = "http://purl.org/dc/elements/1.1/"
+	xsiNS         = "http://www.w3.org/2001/XMLSchema-instance"
+	oaiIDPrefix   = "oai:lgbt-library-catalog:books/"
+	oaiTimeFormat = "2006-01-02T15:04:05Z"
+	oaiDayFormat  = "2006-01-02"
+	oaiPageSize   = 100
+)
+
+type oaiResponse struct {
+	XMLName             xml.Name                `xml:"OAI-PMH"`
+	Xmlns               string                  `xml:"xmlns,attr"`
+	XmlnsXSI            string                  `xml:"xmlns:xsi,attr"`
+	SchemaLocation      string                  `xml:"xsi:schemaLocation,attr"`
+	ResponseDate        string                  `xml:"responseDate"`
+	Request             oaiRequest              `xml:"request"`
+	Errors              []oaiError              `xml:"error"`
+	Identify            *oaiIdentify            `xml:"Identify,omitempty"`
+	ListMetadataFormats *oaiListMetadataFormats `xml:"ListMetadataFormats,omitempty"`
+	GetRecord           *oaiGetRecord           `xml:"GetRecord,omitempty"`
+	ListIdentifiers     *oaiList                `xml:"ListIdentifiers,omitempty"`
+	ListRecords         *oaiList                `xml:"ListRecords,omitempty"`
+}
+
+// oaiRequest echoes the arguments back, all of them left out when the request had an error in them
+type oaiRequest struct {
+	Verb            string `xml:"verb,attr,omitempty"`
+	Identifier      string `xml:"identifier,attr,omitempty"`
+	MetadataPrefix  string `xml:"metadataPrefix,attr,omitempty"`
+	From            string `xml:"from,attr,omitempty"`
+	Until           string `xml:"until,attr,omitempty"`
+	Set             string `xml:"set,attr,omitempty"`
+	ResumptionToken string `xml:"resumptionToken,attr,omitempty"`
+	BaseURL         string `xml:",chardata"`
+}
+
+type oaiError struct {
+	Code    string `xml:"code,attr"`
+	Message string `xml:",chardata"`
+}
+
+type oaiIdentify struct {
+	RepositoryName    string `xml:"repositoryName"`
+	BaseURL           string `xml:"baseURL"`
+	ProtocolVersion   string `xml:"protocolVersion"`
+	AdminEmail        string `xml:"adminEmail"`
+	EarliestDatestamp string `xml:"earliestDatestamp"`
+	DeletedRecord     string `xml:"deletedRecord"`
+	Granularity       string `xml:"granularity"`
+}
+
+type oaiMetadataFormat struct {
+	Prefix    string `xml:"metadataPrefix"`
+	Schema    string `xml:"schema"`
+	Namespace string `xml:"metadataNamespace"`
+}
+
+type oaiListMetadataFormats struct {
+	Formats []oaiMetadataFormat `xml:"metadataFormat"`
+}
+
+type oaiHeader struct {
+	Status     string `xml:"status,attr,omitempty"`
+	Identifier string `xml:"identifier"`
+	Datestamp  string `xml:"datestamp"`
+}
+
+type oaiRecord struct {
+	Header   oaiHeader    `xml:"header"`
+	Metadata *oaiMetadata `xml:"metadata,omitempty"`
+}
+
+type oaiMetadata struct {
+	DC oaiDC `xml:"oai_dc:dc"`
+}
+
+type oaiDC struct {
+	XmlnsOAIDC     string   `xml:"xmlns:oai_dc,attr"`
+	XmlnsDC        string   `xml:"xmlns:dc,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+	Title          string   `xml:"dc:title"`
+	Creators       []string `xml:"dc:creator"`
+	Subjects       []string `xml:"dc:subject"`
+	Publisher      string   `xml:"dc:publisher,omitempty"`
+	Date           string   `xml:"dc:date,omitempty"`
+	Type           string   `xml:"dc:type"`
+	Identifiers    []string `xml:"dc:identifier"`
+}
+
+type oaiGetRecord struct {
+	Record oaiRecord `xml:"record"`
+}
+
+// oaiList is the body of ListIdentifiers (headers) and ListRecords (records)
+type oaiList struct {
+	Headers []oaiHeader `xml:"header"`
+	Records []oaiRecord `xml:"record"`
+	Token   *oaiToken   `xml:"resumptionToken"`
+}
+
+type oaiToken struct {
+	CompleteListSize int    `xml:"completeListSize,attr"`
+	Cursor           int    `xml:"cursor,attr"`
+	Value            string `xml:",chardata"`
+}
+
+var errBadToken = errors.New("bad resumption token")
+
+// oaiHarvest is one ListIdentifiers/ListRecords request. until is exclusive and always set,
+// a harvest that didn't give one is pinned to when it started so records changed halfway
+// through get picked up by the next harvest instead of shifting pages
+type oaiHarvest struct {
+	from, until time.Time
+	afterID     int
+	cursor      int
+}
+
+// the token is the harvest itself, keyset paginated on bookID, so nothing has to be kept server side
+func (h oaiHarvest) token() string {
+	v := url.Values{}
+	v.Set("f", strconv.FormatInt(h.from.Unix(), 10))
+	v.Set("u", strconv.FormatInt(h.until.Unix(), 10))
+	v.Set("a", strconv.Itoa(h.afterID))
+	v.Set("c", strconv.Itoa(h.cursor))
+	return base64.RawURLEncoding.EncodeToString([]byte(v.Encode()))
+}
+
+func parseToken(token string) (oaiHarvest, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return oaiHarvest{}, errBadToken
+	}
+	v, err := url.ParseQuery(string(raw))
+	if err != nil {
+		return oaiHarvest{}, errBadToken
+	}
+	var h oaiHarvest
+	from, err1 := strconv.ParseInt(v.Get("f"), 10, 64)
+	until, err2 := strconv.ParseInt(v.Get("u"), 10, 64)
+	after, err3 := strconv.Atoi(v.Get("a"))
+	cursor, err4 := strconv.Atoi(v.Get("c"))
+	if err := errors.Join(err1, err2, err3, err4); err != nil || cursor < 0 {
+		return oaiHarvest{}, errBadToken
+	}
+	h.from, h.until, h.afterID, h.cursor = time.Unix(from, 0).UTC(), time.Unix(until, 0).UTC(), after, cursor
+	return h, nil
+}
+
+// parseDatestamp takes either granularity, day or second. end asks for the end of the day/second
+// instead of the start, for until
+func parseDatestamp(raw string, end bool) (time.Time, bool, error) {
+	if t, err := time.Parse(oaiTimeFormat, raw); err == nil {
+		if end {
+			t = t.Add(time.Second)
+		}
+		return t, false, nil
+	}
+	t, err := time.Parse(oaiDayFormat, raw)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%q is not a YYYY-MM-DD or YYYY-MM-DDThh:mm:ssZ datestamp", raw)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, true, nil
+}
+
+// which arguments each verb takes, resumptionToken is exclusive: nothing but the verb can come with it
+var oaiVerbs = map[string]struct{ required, optional []string }{
+	"Identify":            {},
+	"ListMetadataFormats": {optional: []string{"identifier"}},
+	"ListSets":            {optional: []string{"resumptionToken"}},
+	"GetRecord":           {required: []string{"identifier", "metadataPrefix"}},
+	"ListIdentifiers":     {required: []string{"metadataPrefix"}, optional: []string{"from", "until", "set", "resumptionToken"}},
+	"ListRecords":         {required: []string{"metadataPrefix"}, optional: []string{"from", "until", "set", "resumptionToken"}},
+}
+
+// checkOAIArgs returns the badVerb/badArgument error for the request, if any
+func checkOAIArgs(args url.Values) *oaiError {
+	verbs := args["verb"]
+	if len(verbs) != 1 {
+		return &oaiError{"badVerb", "exactly one verb is required"}
+	}
+	spec, ok := oaiVerbs[verbs[0]]
+	if !ok {
+		return &oaiError{"badVerb", fmt.Sprintf("%q is not an OAI-PMH verb", verbs[0])}
+	}
+	allowed := map[string]bool{"verb": true}
+	for _, name := range append(spec.required, spec.optional...) {
+		allowed[name] = true
+	}
+	for name, values := range args {
+		if !allowed[name] {
+			return &oaiError{"badArgument", fmt.Sprintf("%s doesn't take %s", verbs[0], name)}
+		}
+		if len(values) > 1 {
+			return &oaiError{"badArgument", name + " is repeated"}
+		}
+	}
+	if args.Has("resumptionToken") {
+		if len(args) > 2 {
+			return &oaiError{"badArgument", "resumptionToken is exclusive, send it with the verb only"}
+		}
+		return nil
+	}
+	for _, name := range spec.required {
+		if args.Get(name) == "" {
+			return &oaiError{"badArgument", "missing " + name}
+		}
+	}
+	return nil
+}
+
+func oaiBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/api/v1/oai"
+}
+
+// GET or POST /oai?verb=...
+func (s *Server) handleOAI() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		args := r.Form
+
+		resp := &oaiResponse{
+			Xmlns:          oaiNS,
+			XmlnsXSI:       xsiNS,
+			SchemaLocation: oaiSchema,
+			ResponseDate:   time.Now().UTC().Format(oaiTimeFormat),
+			Request:        oaiRequest{BaseURL: oaiBaseURL(r)},
+		}
+		if oaiErr := checkOAIArgs(args); oaiErr != nil {
+			resp.Errors = append(resp.Errors, *oaiErr)
+			writeXML(w, "text/xml", resp)
+			return
+		}
+		resp.Request.Verb = args.Get("verb")
+		resp.Request.Identifier = args.Get("identifier")
+		resp.Request.MetadataPrefix = args.Get("metadataPrefix")
+		resp.Request.From = args.Get("from")
+		resp.Request.Until = args.Get("until")
+		resp.Request.Set = args.Get("set")
+		resp.Request.ResumptionToken = args.Get("resumptionToken")
+
+		var err error
+		switch args.Get("verb") {
+		case "Identify":
+			err = s.oaiIdentify(r.Context(), resp)
+		case "ListMetadataFormats":
+			err = s.oaiListMetadataFormats(r.Context(), resp, args.Get("identifier"))
+		case "ListSets":
+			resp.fail("noSetHierarchy", "this repository doesn't have sets")
+		case "GetRecord":
+			err = s.oaiGetRecord(r.Context(), resp, args.Get("identifier"), args.Get("metadataPrefix"))
+		case "ListIdentifiers", "ListRecords":
+			err = s.oaiList(r.Context(), resp, args)
+		}
+		if err != nil {
+			log.Printf("oai-pmh %s: %v", args.Get("verb"), err)
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		writeXML(w, "text/xml", resp)
+	})
+}
+
+func (resp *oaiResponse) fail(code, msg string) {
+	resp.Errors = append(resp.Errors, oaiError{code, msg})
+}
+
+func (s *Server) oaiIdentify(ctx context.Context, resp *oaiResponse) error {
+	var earliest sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+        SELECT LEAST(
+            COALESCE((SELECT MIN(updatedAt) FROM books), NOW()),
+            COALESCE((SELECT MIN(deletedAt) FROM deletedBooks), NOW()))`,
+	).Scan(&earliest)
+	if err != nil {
+		return err
+	}
+	resp.Identify = &oaiIdentify{
+		RepositoryName:    "LGBT Library Catalog",
+		BaseURL:           resp.Request.BaseURL,
+		ProtocolVersion:   "2.0",
+		AdminEmail:        s.oaiAdminEmail,
+		EarliestDatestamp: earliest.Time.UTC().Format(oaiTimeFormat),
+		DeletedRecord:     "persistent",
+		Granularity:       "YYYY-MM-DDThh:mm:ssZ",
+	}
+	return nil
+}
+
+func (s *Server) oaiListMetadataFormats(ctx context.Context, resp *oaiResponse, identifier string) error {
+	if identifier != "" {
+		_, found, err := s.oaiLookup(ctx, identifier)
+		if err != nil {
+			return err
+		}
+		if !found {
+			resp.fail("idDoesNotExist", identifier+" is not in this repository")
+			return nil
+		}
+	}
+	resp.ListMetadataFormats = &oaiListMetadataFormats{Formats: []oaiMetadataFormat{
+		{Prefix: "oai_dc", Schema: oaiDCSchema, Namespace: oaiDCNS},
+	}}
+	return nil
+}
+
+func (s *Server) oaiGetRecord(ctx context.Context, resp *oaiResponse, identifier, prefix string) error {
+	rec, found, err := s.oaiLookup(ctx, identifier)
+	if err != nil {
+		return err
+	}
+	if !found {
+		resp.fail("idDoesNotExist", identifier+" is not in this repository")
+		return nil
+	}
+	if prefix != "oai_dc" {
+		resp.fail("cannotDisseminateFormat", "only oai_dc is supported")
+		return nil
+	}
+	resp.GetRecord = &oaiGetRecord{Record: rec}
+	return nil
+}
+
+// oaiLookup finds a record by its oai identifier, a deleted book comes back as a header with status deleted
+func (s *Server) oaiLookup(ctx context.Context, identifier string) (oaiRecord, bool, error) {
+	raw, ok := strings.CutPrefix(identifier, oaiIDPrefix)
+	if !ok {
+		return oaiRecord{}, false, nil
+	}
+	bookID, err := strconv.Atoi(raw)
+	if err != nil {
+		return oaiRecord{}, false, nil
+	}
+	books, _, err := s.queryBooksWithFilters(ctx, BookFilters{IDs: []int{bookID}}, PaginationParams{Limit: 1})
+	if err != nil {
+		return oaiRecord{}, false, err
+	}
+	if len(books) > 0 {
+		return oaiBookRecord(books[0]), true, nil
+	}
+	var deletedAt time.Time
+	err = s.db.QueryRowContext(ctx, `SELECT deletedAt FROM deletedBooks WHERE bookID = ?`, bookID).Scan(&deletedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return oaiRecord{}, false, nil
+	}
+	if err != nil {
+		return oaiRecord{}, false, err
+	}
+	return oaiRecord{Header: oaiHeader{Status: "deleted", Identifier: identifier, Datestamp: deletedAt.UTC().Format(oaiTimeFormat)}}, true, nil
+}
+
+func oaiBookRecord(b book) oaiRecord {
+	dc := oaiDC{
+		XmlnsOAIDC:     oaiDCNS,
+		XmlnsDC:        dcElementsNS,
+		SchemaLocation: oaiDCNS + " " + oaiDCSchema,
+		Title:          b.Title,
+		Publisher:      nullable(b.Publisher),
+		Date:           pubYear(b),
+		Type:           "Text",
+	}
+	for _, a := range b.Authors {
+		name := a.LName
+		if a.FName != nil && *a.FName != "" {
+			name += ", " + *a.FName
+		}
+		dc.Creators = append(dc.Creators, name)
+	}
+	for _, sub := range b.Subjects {
+		dc.Subjects = append(dc.Subjects, sub.PrefLabel)
+	}
+	dc.Subjects = append(dc.Subjects, b.Tags...)
+	if b.ISBN != nil {
+		dc.Identifiers = append(dc.Identifiers, "urn:isbn:"+*b.ISBN)
+	}
+	return oaiRecord{
+		Header:   oaiHeader{Identifier: oaiIDPrefix + strconv.Itoa(b.ID), Datestamp: b.UpdatedAt.UTC().Format(oaiTimeFormat)},
+		Metadata: &oaiMetadata{DC: dc},
+	}
+}
+
+// oaiList does ListIdentifiers and ListRecords, a page of oaiPageSize records at a time
+func (s *Server) oaiList(ctx context.Context, resp *oaiResponse, args url.Values) error {
+	var h oaiHarvest
+	if token := args.Get("resumptionToken"); token != "" {
+		var err error
+		if h, err = parseToken(token); err != nil {
+			resp.fail("badResumptionToken", "the resumption token is invalid")
+			return nil
+		}
+	} else {
+		if args.Get("metadataPrefix") != "oai_dc" {
+			resp.fail("cannotDisseminateFormat", "only oai_dc is supported")
+			return nil
+		}
+		if args.Get("set") != "" {
+			resp.fail("noSetHierarchy", "this repository doesn't have sets")
+			return nil
+		}
+		h.until = time.Now().UTC().Truncate(time.Second).Add(time.Second)
+		var fromDay, untilDay bool
+		var err error
+		if raw := args.Get("from"); raw != "" {
+			if h.from, fromDay, err = parseDatestamp(raw, false); err != nil {
+				resp.fail("badArgument", "from: "+err.Error())
+				return nil
+			}
+		}
+		if raw := args.Get("until"); raw != "" {
+			if h.until, untilDay, err = parseDatestamp(raw, true); err != nil {
+				resp.fail("badArgument", "until: "+err.Error())
+				return nil
+			}
+		}
+		if args.Get("from") != "" && args.Get("until") != "" {
+			if fromDay != untilDay {
+				resp.fail("badArgument", "from and until must have the same granularity")
+				return nil
+			}
+			if !h.from.Before(h.until) {
+				resp.fail("badArgument", "from is later than until")
+				return nil
+			}
+		}
+	}
+
+	//live books by updatedAt and deleted ones by deletedAt, bookIDs are never reused so one
+	//keyset over both is stable
+	var total int
+	err := s.db.QueryRowContext(ctx, `
+        SELECT (SELECT COUNT(*) FROM books WHERE updatedAt >= ? AND updatedAt < ?)
+             + (SELECT COUNT(*) FROM deletedBooks WHERE deletedAt >= ? AND deletedAt < ?)`,
+		h.from, h.until, h.from, h.until,
+	).Scan(&total)
+	if err != nil {
+		return err
+	}
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT bookID, updatedAt, false FROM books WHERE updatedAt >= ? AND updatedAt < ? AND bookID > ?
+        UNION ALL
+        SELECT bookID, deletedAt, true FROM deletedBooks WHERE deletedAt >= ? AND deletedAt < ? AND bookID > ?
+        ORDER BY bookID LIMIT ?`,
+		h.from, h.until, h.afterID, h.from, h.until, h.afterID, oaiPageSize+1,
+	)
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		bookID    int
+		datestamp time.Time
+		deleted   bool
+	}
+	var page []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.bookID, &e.datestamp, &e.deleted); err != nil {
+			rows.Close()
+			return err
+		}
+		page = append(page, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(page) == 0 {
+		resp.fail("noRecordsMatch", "no records match the request")
+		return nil
+	}
+
+	//one extra row tells us whether there's another page
+	more := len(page) > oaiPageSize
+	if more {
+		page = page[:oaiPageSize]
+	}
+	list := &oaiList{}
+	if more || args.Has("resumptionToken") {
+		list.Token = &oaiToken{CompleteListSize: total, Cursor: h.cursor}
+		if more {
+			next := h
+			next.afterID, next.cursor = page[len(page)-1].bookID, h.cursor+len(page)
+			list.Token.Value = next.token()
+		}
+	}
+
+	verb := args.Get("verb")
+	var live []int
+	for _, e := range page {
+		if !e.deleted {
+			live = append(live, e.bookID)
+		}
+	}
+	books := make(map[int]book)
+	if verb == "ListRecords" && len(live) > 0 {
+		found, _, err := s.queryBooksWithFilters(ctx, BookFilters{IDs: live}, PaginationParams{Limit: len(live)})
+		if err != nil {
+			return err
+		}
+		for _, b := range found {
+			books[b.ID] = b
+		}
+	}
+	for _, e := range page {
+		header := oaiHeader{Identifier: oaiIDPrefix + strconv.Itoa(e.bookID), Datestamp: e.datestamp.UTC().Format(oaiTimeFormat)}
+		if e.deleted {
+			header.Status = "deleted"
+		}
+		if verb == "ListIdentifiers" {
+			list.Headers = append(list.Headers, header)
+			continue
+		}
+		b, ok := books[e.bookID]
+		if e.deleted || !ok {
+			//deleted between the two queries
+			header.Status = "deleted"
+			list.Records = append(list.Records, oaiRecord{Header: header})
+			continue
+		}
+		list.Records = append(list.Records, oaiBookRecord(b))
+	}
+	if verb == "ListIdentifiers" {
+		resp.ListIdentifiers = list
+	} else {
+		resp.ListRecords = list
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// harvesters don't log in, and OAI-PMH 2.0 says a provider has to take POST as well as GET
+func TestOAIAnonymousPost(t *testing.T) {
+	earliest := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := newTestServer(t, func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		if !strings.Contains(query, "MIN(updatedAt)") {
+			t.Errorf("unexpected query %s", query)
+		}
+		return []string{"earliest"}, [][]driver.Value{{earliest}}, nil
+	})
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		var r *http.Request
+		if method == http.MethodPost {
+			r = httptest.NewRequest(method, "/api/v1/oai", strings.NewReader(url.Values{"verb": {"Identify"}}.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			r = httptest.NewRequest(method, "/api/v1/oai?verb=Identify", nil)
+		}
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s /oai: status %d, body %s", method, w.Code, w.Body)
+		}
+		body := w.Body.String()
+		for _, want := range []string{`<request verb="Identify">http://example.com/api/v1/oai</request>`, "<earliestDatestamp>2024-03-01T12:00:00Z</earliestDatestamp>"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s /oai: no %s in\n%s", method, want, body)
+			}
+		}
+	}
+}
+
+// every other write still needs a login
+func TestAnonymousWritesNeedLogin(t *testing.T) {
+	s := newTestServer(t, func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		t.Errorf("unexpected query %s", query)
+		return nil, nil, nil
+	})
+	for _, tt := range []struct{ method, path string }{
+		{http.MethodPost, "/api/v1/books"},
+		{http.MethodDelete, "/api/v1/books/1"},
+		{http.MethodPost, "/api/v1/loans/checkout"},
+		{http.MethodPost, "/api/v1/users/"},
+		{http.MethodPut, "/api/v1/oai"},
+	} {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader("{}")))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: status %d, want 401", tt.method, tt.path, w.Code)
+		}
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestCheckOAIArgs(t *testing.T) {
+	tests := []struct {
+		query string
+		code  string //"" for no error
+		msg   string
+	}{
+		{"verb=Identify", "", ""},
+		{"verb=ListMetadataFormats", "", ""},
+		{"verb=ListMetadataFormats&identifier=oai:lgbt-library-catalog:books/1", "", ""},
+		{"verb=GetRecord&identifier=oai:lgbt-library-catalog:books/1&metadataPrefix=oai_dc", "", ""},
+		{"verb=ListRecords&metadataPrefix=oai_dc&from=2024-01-01&until=2024-02-01", "", ""},
+		{"verb=ListIdentifiers&resumptionToken=abc", "", ""},
+		{"verb=ListSets&resumptionToken=abc", "", ""},
+		{"", "badVerb", "exactly one verb is required"},
+		{"verb=Identify&verb=Identify", "badVerb", "exactly one verb is required"},
+		{"verb=ListBooks", "badVerb", `"ListBooks" is not an OAI-PMH verb`},
+		{"verb=identify", "badVerb", `"identify" is not an OAI-PMH verb`},
+		{"verb=Identify&identifier=x", "badArgument", "Identify doesn't take identifier"},
+		{"verb=ListRecords&metadataPrefix=oai_dc&isbn=123", "badArgument", "ListRecords doesn't take isbn"},
+		{"verb=ListRecords&metadataPrefix=oai_dc&metadataPrefix=marc", "badArgument", "metadataPrefix is repeated"},
+		{"verb=GetRecord&identifier=oai:lgbt-library-catalog:books/1", "badArgument", "missing metadataPrefix"},
+		{"verb=GetRecord&identifier=&metadataPrefix=oai_dc", "badArgument", "missing identifier"},
+		{"verb=ListRecords", "badArgument", "missing metadataPrefix"},
+		{"verb=ListRecords&resumptionToken=abc&metadataPrefix=oai_dc", "badArgument", "resumptionToken is exclusive, send it with the verb only"},
+		{"verb=ListIdentifiers&resumptionToken=abc&until=2024-01-01", "badArgument", "resumptionToken is exclusive, send it with the verb only"},
+		{"verb=GetRecord&resumptionToken=abc", "badArgument", "GetRecord doesn't take resumptionToken"},
+	}
+	for _, tt := range tests {
+		args, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := checkOAIArgs(args)
+		switch {
+		case tt.code == "" && got != nil:
+			t.Errorf("checkOAIArgs(%s) = %+v, want no error", tt.query, *got)
+		case tt.code != "" && (got == nil || got.Code != tt.code || got.Message != tt.msg):
+			t.Errorf("checkOAIArgs(%s) = %+v, want {%s %s}", tt.query, got, tt.code, tt.msg)
+		}
+	}
+}
+
+func TestOAIToken(t *testing.T) {
+	tests := []oaiHarvest{
+		{until: time.Date(2024, 3, 1, 12, 0, 1, 0, time.UTC)},
+		{from: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), until: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), afterID: 1099, cursor: 100},
+		{from: time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), until: time.Date(1970, 1, 1, 0, 0, 1, 0, time.UTC), afterID: 0, cursor: 0},
+	}
+	for _, h := range tests {
+		got, err := parseToken(h.token())
+		if err != nil {
+			t.Errorf("parseToken(%v): %v", h, err)
+			continue
+		}
+		//a harvest without from is everything since the zero time, that has to survive the round trip
+		if !got.from.Equal(h.from) || got.from.IsZero() != h.from.IsZero() || !got.until.Equal(h.until) ||
+			got.afterID != h.afterID || got.cursor != h.cursor {
+			t.Errorf("token round trip: got %+v, want %+v", got, h)
+		}
+	}
+
+	for _, bad := range []string{
+		"",
+		"not base64!",
+		base64URL("f=1&u=2&a=3"),       //no cursor
+		oaiHarvest{cursor: -1}.token(), //negative cursor
+		base64URL("f=x&u=2&a=3&c=4"),
+		base64URL("f=1&u=2&a=3&c=4%zz"), //bad escape
+		base64URL("f=1&u=2&a=3.5&c=4"),  //afterID isn't an int
+		base64URL("f=1&u=99999999999999999999&a=3&c=4"),
+	} {
+		if _, err := parseToken(bad); !errors.Is(err, errBadToken) {
+			t.Errorf("parseToken(%q) = %v, want errBadToken", bad, err)
+		}
+	}
+}
+
+func base64URL(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func TestParseDatestamp(t *testing.T) {
+	tests := []struct {
+		raw     string
+		end     bool
+		want    time.Time
+		day     bool
+		wantErr bool
+	}{
+		{"2024-03-01", false, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), true, false},
+		//until is exclusive inside the api, so an until day runs to the start of the next one
+		{"2024-03-01", true, time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), true, false},
+		{"2024-12-31", true, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), true, false},
+		{"2024-03-01T12:30:59Z", false, time.Date(2024, 3, 1, 12, 30, 59, 0, time.UTC), false, false},
+		{"2024-03-01T12:30:59Z", true, time.Date(2024, 3, 1, 12, 31, 0, 0, time.UTC), false, false},
+		{"2024-03-01T12:30Z", false, time.Time{}, false, true},
+		{"2024-03-01T12:30:59+01:00", false, time.Time{}, false, true},
+		{"2024-3-1", false, time.Time{}, false, true},
+		{"2024-02-30", false, time.Time{}, false, true},
+		{"yesterday", false, time.Time{}, false, true},
+		{"", false, time.Time{}, false, true},
+	}
+	for _, tt := range tests {
+		got, day, err := parseDatestamp(tt.raw, tt.end)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDatestamp(%q, %v) error = %v, want error %v", tt.raw, tt.end, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (!got.Equal(tt.want) || day != tt.day) {
+			t.Errorf("parseDatestamp(%q, %v) = %v, %v, want %v, %v", tt.raw, tt.end, got, day, tt.want, tt.day)
+		}
+	}
+}
+
+func TestOAIBookRecord(t *testing.T) {
+	b := book{
+		ID:        1042,
+		ISBN:      ptr("9781555838539"),
+		Title:     "Stone Butch Blues",
+		PubDate:   ptr("1993-03-01"),
+		Publisher: ptr("Firebrand Books"),
+		UpdatedAt: time.Date(2024, 3, 1, 7, 30, 0, 0, time.FixedZone("EST", -5*3600)),
+		Authors:   []author{{AuthID: 1, LName: "Feinberg", FName: ptr("Leslie")}, {AuthID: 2, LName: "Kai"}, {AuthID: 3, LName: "Lorde", FName: ptr("")}},
+		Tags:      []string{"fiction", "butch & femme"},
+		Subjects:  []subject{{URI: "https://homosaurus.org/v3/homoit0000166", PrefLabel: "Butches"}},
+	}
+	out, err := xml.MarshalIndent(oaiBookRecord(b), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<oaiRecord>
+  <header>
+    <identifier>oai:lgbt-library-catalog:books/1042</identifier>
+    <datestamp>2024-03-01T12:30:00Z</datestamp>
+  </header>
+  <metadata>
+    <oai_dc:dc xmlns:oai_dc="http://www.openarchives.org/OAI/2.0/oai_dc/" xmlns:dc="http://purl.org/dc/elements/1.1/" xsi:schemaLocation="http://www.openarchives.org/OAI/2.0/oai_dc/ http://www.openarchives.org/OAI/2.0/oai_dc.xsd">
+      <dc:title>Stone Butch Blues</dc:title>
+      <dc:creator>Feinberg, Leslie</dc:creator>
+      <dc:creator>Kai</dc:creator>
+      <dc:creator>Lorde</dc:creator>
+      <dc:subject>Butches</dc:subject>
+      <dc:subject>fiction</dc:subject>
+      <dc:subject>butch &amp; femme</dc:subject>
+      <dc:publisher>Firebrand Books</dc:publisher>
+      <dc:date>1993</dc:date>
+      <dc:type>Text</dc:type>
+      <dc:identifier>urn:isbn:9781555838539</dc:identifier>
+    </oai_dc:dc>
+  </metadata>
+</oaiRecord>`
+	if string(out) != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+
+	//no publisher, date or isbn: those elements are left out, not empty
+	out, err = xml.Marshal(oaiBookRecord(book{ID: 7, Title: "Zami"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, absent := range []string{"dc:publisher", "dc:date", "dc:identifier", "status="} {
+		if strings.Contains(string(out), absent) {
+			t.Errorf("%s in %s", absent, out)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package api
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OPDS 1.2 catalog for e-reader apps: a navigation feed at /opds that links to acquisition
+// feeds of books. every link is absolute from the server root so feeds can be cached anywhere
+const (
+	opdsRoot        = "/api/v1/opds"
+	opdsPageSize    = 25
+	opdsNavigation  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisition = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	openSearchType  = "application/opensearchdescription+xml"
+
+	atomNS       = "http://www.w3.org/2005/Atom"
+	opdsNS       = "http://opds-spec.org/2010/catalog"
+	dcNS         = "http://purl.org/dc/terms/"
+	openSearchNS = "http://a9.com/-/spec/opensearch/1.1/"
+)
+
+type atomFeed struct {
+	XMLName      xml.Name    `xml:"feed"`
+	Xmlns        string      `xml:"xmlns,attr"`
+	XmlnsOPDS    string      `xml:"xmlns:opds,attr"`
+	XmlnsDC      string      `xml:"xmlns:dc,attr"`
+	XmlnsOS      string      `xml:"xmlns:opensearch,attr"`
+	ID           string      `xml:"id"`
+	Title        string      `xml:"title"`
+	Updated      string      `xml:"updated"`
+	Author       atomAuthor  `xml:"author"`
+	TotalResults *int        `xml:"opensearch:totalResults,omitempty"`
+	ItemsPerPage *int        `xml:"opensearch:itemsPerPage,omitempty"`
+	StartIndex   *int        `xml:"opensearch:startIndex,omitempty"`
+	Links        []atomLink  `xml:"link"`
+	Entries      []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomLink struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+	//only on the borrow link, copies are physical so this is the shelf count
+	Availability *opdsAvailability `xml:"opds:availability,omitempty"`
+	Copies       *opdsCopies       `xml:"opds:copies,omitempty"`
+}
+
+type opdsAvailability struct {
+	Status string `xml:"status,attr"`
+}
+
+type opdsCopies struct {
+	Total     int `xml:"total,attr"`
+	Available int `xml:"available,attr"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Authors    []atomAuthor   `xml:"author"`
+	Identifier string         `xml:"dc:identifier,omitempty"`
+	Publisher  string         `xml:"dc:publisher,omitempty"`
+	Issued     string         `xml:"dc:issued,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Content    *atomContent   `xml:"content,omitempty"`
+	Links      []atomLink     `xml:"link"`
+}
+
+type openSearchDescription struct {
+	XMLName        xml.Name `xml:"OpenSearchDescription"`
+	Xmlns          string   `xml:"xmlns,attr"`
+	ShortName      string   `xml:"ShortName"`
+	Description    string   `xml:"Description"`
+	InputEncoding  string   `xml:"InputEncoding"`
+	OutputEncoding string   `xml:"OutputEncoding"`
+	URL            struct {
+		Type     string `xml:"type,attr"`
+		Template string `xml:"template,attr"`
+	} `xml:"Url"`
+}
+
+func newFeed(id, title string) *atomFeed {
+	return &atomFeed{
+		Xmlns: atomNS, XmlnsOPDS: opdsNS, XmlnsDC: dcNS, XmlnsOS: openSearchNS,
+		ID:      "urn:lgbt-library-catalog:opds:" + id,
+		Title:   title,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: "LGBT Library Catalog"},
+		Links: []atomLink{
+			{Rel: "start", Href: opdsRoot, Type: opdsNavigation},
+			{Rel: "search", Href: opdsRoot + "/opensearch.xml", Type: openSearchType},
+		},
+	}
+}
+
+func writeXML(w http.ResponseWriter, contentType string, v any) {
+	out, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Printf("xml: %v", err)
+		http.Error(w, "encoding failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+	w.Write([]byte("\n"))
+}
+
+// GET /opds and everything under it
+func (s *Server) handleOPDS() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		feed, sub, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/opds"), "/"), "/")
+		pagination := parsePagination(r)
+		if r.URL.Query().Get("limit") == "" {
+			pagination.Limit = opdsPageSize
+		}
+
+		switch {
+		case feed == "" && sub == "":
+			s.opdsRootFeed(w)
+
+		case feed == "opensearch.xml" && sub == "":
+			desc := openSearchDescription{
+				Xmlns:          openSearchNS,
+				ShortName:      "LGBT Library",
+				Description:    "Search the catalog by title, author or subject",
+				InputEncoding:  "UTF-8",
+				OutputEncoding: "UTF-8",
+			}
+			desc.URL.Type = opdsAcquisition
+			desc.URL.Template = opdsRoot + "/search?q={searchTerms}"
+			writeXML(w, openSearchType, desc)
+
+		case feed == "new" && sub == "":
+			s.opdsBooks(w, r, newFeed("new", "New arrivals"), BookFilters{OrderBy: "createdAt DESC, bookID DESC"}, pagination)
+
+		case feed == "popular" && sub == "":
+			s.opdsBooks(w, r, newFeed("popular", "Popular"), BookFilters{OrderBy: "loanMetrics DESC, bookID"}, pagination)
+
+		case feed == "search" && sub == "":
+			q := strings.TrimSpace(r.URL.Query().Get("q"))
+			if q == "" {
+				http.Error(w, "missing q", http.StatusBadRequest)
+				return
+			}
+			results, total, err := s.searchBooks(r.Context(), q, pagination)
+			if err != nil {
+				http.Error(w, "search query failed", http.StatusInternalServerError)
+				return
+			}
+			books := make([]book, len(results))
+			for i, res := range results {
+				books[i] = res.book
+			}
+			writeAcquisition(w, r, newFeed("search:"+url.QueryEscape(q), "Search results for "+q), books, pagination, total)
+
+		case feed == "tags" && sub == "":
+			s.opdsTagList(w, r, pagination)
+
+		case feed == "tags":
+			tag, err := normalizeTag(sub)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			s.opdsBooks(w, r, newFeed("tag:"+url.PathEscape(tag), "Tagged "+tag), BookFilters{Tag: tag}, pagination)
+
+		case feed == "authors" && sub == "":
+			s.opdsAuthorList(w, r, pagination)
+
+		case feed == "authors":
+			authID, err := strconv.Atoi(sub)
+			if err != nil {
+				http.Error(w, "invalid author id", http.StatusBadRequest)
+				return
+			}
+			a, err := s.authorByID(r.Context(), authID)
+			if errors.Is(err, errAuthorNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			if err != nil {
+				http.Error(w, "query failed", http.StatusInternalServerError)
+				return
+			}
+			s.opdsBooks(w, r, newFeed("author:"+sub, "Books by "+authorName(a)), BookFilters{AuthorID: authID}, pagination)
+
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func (s *Server) opdsRootFeed(w http.ResponseWriter) {
+	feed := newFeed("root", "LGBT Library Catalog")
+	feed.Links = append(feed.Links, atomLink{Rel: "self", Href: opdsRoot, Type: opdsNavigation})
+	for _, nav := range []struct{ path, title, rel, kind, summary string }{
+		{"/new", "New arrivals", "http://opds-spec.org/sort/new", opdsAcquisition, "The most recently added books"},
+		{"/popular", "Popular", "http://opds-spec.org/sort/popular", opdsAcquisition, "The most borrowed books"},
+		{"/tags", "By tag", "subsection", opdsNavigation, "Browse books by tag"},
+		{"/authors", "By author", "subsection", opdsNavigation, "Browse books by author"},
+	} {
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   nav.title,
+			ID:      feed.ID + nav.path,
+			Updated: feed.Updated,
+			Content: &atomContent{Type: "text", Text: nav.summary},
+			Links:   []atomLink{{Rel: nav.rel, Href: opdsRoot + nav.path, Type: nav.kind}},
+		})
+	}
+	writeXML(w, opdsNavigation, feed)
+}
+
+// paginate adds the opensearch counts and first/previous/next links for a page of a feed
+func (feed *atomFeed) paginate(r *http.Request, kind string, pagination PaginationParams, total int) {
+	start := pagination.Offset + 1
+	feed.TotalResults, feed.ItemsPerPage, feed.StartIndex = &total, &pagination.Limit, &start
+
+	page := func(rel string, offset int) {
+		q := r.URL.Query()
+		q.Set("offset", strconv.Itoa(offset))
+		q.Set("limit", strconv.Itoa(pagination.Limit))
+		feed.Links = append(feed.Links, atomLink{Rel: rel, Href: opdsRoot + strings.TrimPrefix(r.URL.Path, "/opds") + "?" + q.Encode(), Type: kind})
+	}
+	page("self", pagination.Offset)
+	if pagination.Offset > 0 {
+		page("first", 0)
+		page("previous", max(pagination.Offset-pagination.Limit, 0))
+	}
+	if pagination.Offset+pagination.Limit < total {
+		page("next", pagination.Offset+pagination.Limit)
+	}
+}
+
+// opdsBooks writes one page of an acquisition feed
+func (s *Server) opdsBooks(w http.ResponseWriter, r *http.Request, feed *atomFeed, filters BookFilters, pagination PaginationParams) {
+	books, total, err := s.queryBooksWithFilters(r.Context(), filters, pagination)
+	if err != nil {
+		http.Error(w, "query failed", http.StatusInternalServerError)
+		return
+	}
+	writeAcquisition(w, r, feed, books, pagination, total)
+}
+
+func writeAcquisition(w http.ResponseWriter, r *http.Request, feed *atomFeed, books []book, pagination PaginationParams, total int) {
+	feed.paginate(r, opdsAcquisition, pagination, total)
+	for _, b := range books {
+		feed.Entries = append(feed.Entries, opdsEntry(b))
+	}
+	writeXML(w, opdsAcquisition, feed)
+}
+
+func opdsEntry(b book) atomEntry {
+	e := atomEntry{
+		Title:     b.Title,
+		ID:        fmt.Sprintf("urn:lgbt-library-catalog:book:%d", b.ID),
+		Updated:   b.UpdatedAt.UTC().Format(time.RFC3339),
+		Publisher: nullable(b.Publisher),
+		Issued:    pubYear(b),
+	}
+	if b.ISBN != nil {
+		e.Identifier = "urn:isbn:" + *b.ISBN
+	}
+	for _, a := range b.Authors {
+		e.Authors = append(e.Authors, atomAuthor{Name: authorName(a), URI: fmt.Sprintf("%s/authors/%d", opdsRoot, a.AuthID)})
+	}
+	for _, t := range b.Tags {
+		e.Categories = append(e.Categories, atomCategory{Term: t, Label: t})
+	}
+	for _, sub := range b.Subjects {
+		e.Categories = append(e.Categories, atomCategory{Term: sub.URI, Label: sub.PrefLabel})
+	}
+	if b.Edition != nil {
+		e.Content = &atomContent{Type: "text", Text: *b.Edition}
+	}
+
+	//there's nothing to download, the acquisition is borrowing a physical copy (placing a hold)
+	status := "unavailable"
+	if b.Available > 0 {
+		status = "available"
+	}
+	e.Links = []atomLink{
+		{Rel: "alternate", Href: fmt.Sprintf("/api/v1/books/%d", b.ID), Type: "application/json"},
+		{
+			Rel:          "http://opds-spec.org/acquisition/borrow",
+			Href:         fmt.Sprintf("/api/v1/books/%d/holds", b.ID),
+			Type:         "application/json",
+			Availability: &opdsAvailability{Status: status},
+			Copies:       &opdsCopies{Total: b.Copies, Available: b.Available},
+		},
+	}
+	if b.CoverURL != nil {
+		e.Links = append(e.Links,
+			atomLink{Rel: "http://opds-spec.org/image", Href: *b.CoverURL + "&size=large", Type: "image/jpeg"},
+			atomLink{Rel: "http://opds-spec.org/image/thumbnail", Href: *b.CoverURL + "&size=small", Type: "image/jpeg"},
+		)
+	}
+	return e
+}
+
+func authorName(a author) string {
+	if a.FName != nil && *a.FName != "" {
+		return *a.FName + " " + a.LName
+	}
+	return a.LName
+}
+
+// GET /opds/tags, the tag cloud as a navigation feed, most used first
+func (s *Server) opdsTagList(w http.ResponseWriter, r *http.Request, pagination PaginationParams) {
+	var total int
+	if err := s.db.QueryRowContext(r.Context(), `SELECT COUNT(DISTINCT tag) FROM booktags`).Scan(&total); err != nil {
+		http.Error(w, "query failed", http.StatusInternalServerError)
+		return
+	}
+	rows, err := s.db.QueryContext(r.Context(), `
+        SELECT tag, COUNT(*) AS uses FROM booktags
+        GROUP BY tag ORDER BY uses DESC, tag LIMIT ? OFFSET ?`,
+		pagination.Limit, pagination.Offset,
+	)
+	if err != nil {
+		http.Error(w, "query failed", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	feed := newFeed("tags", "By tag")
+	feed.paginate(r, opdsNavigation, pagination, total)
+	for rows.Next() {
+		var tag string
+		var uses int
+		if err := rows.Scan(&tag, &uses); err != nil {
+			http.Error(w, "scan failed", http.StatusInternalServerError)
+			return
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   tag,
+			ID:      feed.ID + ":" + url.PathEscape(tag),
+			Updated: feed.Updated,
+			Content: &atomContent{Type: "text", Text: fmt.Sprintf("%d books", uses)},
+			Links:   []atomLink{{Rel: "subsection", Href: opdsRoot + "/tags/" + url.PathEscape(tag), Type: opdsAcquisition}},
+		})
+	}
+	writeXML(w, opdsNavigation, feed)
+}
+
+// GET /opds/authors, authors with at least one book, by last name
+func (s *Server) opdsAuthorList(w http.ResponseWriter, r *http.Request, pagination PaginationParams) {
+	var total int
+	if err := s.db.QueryRowContext(r.Context(), `SELECT COUNT(DISTINCT authID) FROM bookAuthor`).Scan(&total); err != nil {
+		http.Error(w, "query failed", http.StatusInternalServerError)
+		return
+	}
+	rows, err := s.db.QueryContext(r.Context(), `
+        SELECT a.authID, a.lname, a.fname, COUNT(*) FROM authors a
+        JOIN bookAuthor ba ON ba.authID = a.authID
+        GROUP BY a.authID, a.lname, a.fname
+        ORDER BY a.lname, a.fname, a.authID LIMIT ? OFFSET ?`,
+		pagination.Limit, pagination.Offset,
+	)
+	if err != nil {
+		http.Error(w, "query failed", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	feed := newFeed("authors", "By author")
+	feed.paginate(r, opdsNavigation, pagination, total)
+	for rows.Next() {
+		var a author
+		var books int
+		if err := rows.Scan(&a.AuthID, &a.LName, &a.FName, &books); err != nil {
+			http.Error(w, "scan failed", http.StatusInternalServerError)
+			return
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   authorName(a),
+			ID:      fmt.Sprintf("%s:%d", feed.ID, a.AuthID),
+			Updated: feed.Updated,
+			Content: &atomContent{Type: "text", Text: fmt.Sprintf("%d books", books)},
+			Links:   []atomLink{{Rel: "subsection", Href: fmt.Sprintf("%s/authors/%d", opdsRoot, a.AuthID), Type: opdsAcquisition}},
+		})
+	}
+	writeXML(w, opdsNavigation, feed)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOPDSAcquisitionFeed(t *testing.T) {
+	b := book{
+		ID:        7,
+		ISBN:      ptr("9780895941220"),
+		Title:     "Stone Butch Blues",
+		PubDate:   ptr("1993-03-01"),
+		Publisher: ptr("Firebrand"),
+		Copies:    2,
+		Available: 1,
+		UpdatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Authors:   []author{{AuthID: 3, LName: "Feinberg", FName: ptr("Leslie")}},
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/opds/new?offset=25&limit=25", nil)
+	writeAcquisition(w, r, newFeed("new", "New arrivals"), []book{b}, PaginationParams{Limit: 25, Offset: 25}, 60)
+
+	if ct := w.Header().Get("Content-Type"); ct != opdsAcquisition+"; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	out := w.Body.String()
+	for _, want := range []string{
+		`xmlns="` + atomNS + `"`,
+		`xmlns:opds="` + opdsNS + `"`,
+		`xmlns:dc="` + dcNS + `"`,
+		`xmlns:opensearch="` + openSearchNS + `"`,
+		`<opensearch:totalResults>60</opensearch:totalResults>`,
+		`<opensearch:itemsPerPage>25</opensearch:itemsPerPage>`,
+		`<opensearch:startIndex>26</opensearch:startIndex>`,
+		`<dc:identifier>urn:isbn:9780895941220</dc:identifier>`,
+		`<dc:publisher>Firebrand</dc:publisher>`,
+		`<dc:issued>1993</dc:issued>`,
+		`<opds:availability status="available"></opds:availability>`,
+		`<opds:copies total="2" available="1"></opds:copies>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("feed is missing %s\n%s", want, out)
+		}
+	}
+
+	//a namespace aware reader has to resolve every prefix, not just see the same text
+	found := make(map[xml.Name]string)
+	d := xml.NewDecoder(strings.NewReader(out))
+	var text string
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("feed doesn't parse: %v", err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			text = ""
+			if tok.Name.Space == atomNS && tok.Name.Local == "feed" {
+				found[tok.Name] = "root"
+			}
+		case xml.CharData:
+			text += string(tok)
+		case xml.EndElement:
+			if _, ok := found[tok.Name]; !ok {
+				found[tok.Name] = text
+			}
+		}
+	}
+	for name, want := range map[xml.Name]string{
+		{Space: atomNS, Local: "feed"}:               "root",
+		{Space: openSearchNS, Local: "totalResults"}: "60",
+		{Space: openSearchNS, Local: "itemsPerPage"}: "25",
+		{Space: openSearchNS, Local: "startIndex"}:   "26",
+		{Space: dcNS, Local: "identifier"}:           "urn:isbn:9780895941220",
+		{Space: dcNS, Local: "publisher"}:            "Firebrand",
+		{Space: dcNS, Local: "issued"}:               "1993",
+		{Space: opdsNS, Local: "availability"}:       "",
+		{Space: atomNS, Local: "title"}:              "New arrivals",
+	} {
+		if got, ok := found[name]; !ok || got != want {
+			t.Errorf("%s %s: got %q (found %v), want %q", name.Space, name.Local, got, ok, want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// role mirrors the users.role enum, ordered so a higher role can do everything a lower one can
+type role int
+
+const (
+	roleGuest role = iota
+	rolePatron
+	roleStaff
+	roleAdmin
+)
+
+var roleNames = [...]string{"guest", "patron", "staff", "admin"}
+
+func (r role) String() string {
+	return roleNames[r]
+}
+
+func parseRole(name string) (role, bool) {
+	for i, n := range roleNames {
+		if n == name {
+			return role(i), true
+		}
+	}
+	return roleGuest, false
+}
+
+// caller is whoever made the request. anonymous requests and caseIDs that aren't in
+// the users table yet are treated as guests.
+type caller struct {
+	CaseID       string
+	Role         role
+	IsRestricted bool
+}
+
+// canActFor reports whether the caller may read or change records belonging to caseID,
+// patrons only get their own, staff and admins get everyone's
+func (c caller) canActFor(caseID string) bool {
+	if c.Role >= roleStaff {
+		return true
+	}
+	return c.CaseID != "" && c.CaseID == caseID
+}
+
+type permission struct {
+	method string
+	//pattern against the path after /api/v1, * matches one whole non-empty segment (see matchPath)
+	path string
+	min  role
+	//restricted users are refused even if their role is high enough
+	unrestricted bool
+}
+
+// permissions is the access policy for the whole api in one place. a request is checked against
+// the first rule that matches its method and path. anything not listed here is readable by
+// everyone and writable by staff only, so new write endpoints are locked down until someone adds a rule.
+// ownership checks (patrons only seeing their own loans etc) live in the handlers via canActFor.
+var permissions = []permission{
+	{method: http.MethodPost, path: "/books", min: roleStaff},
+	{method: http.MethodPost, path: "/books/import", min: roleStaff},
+	{method: http.MethodPatch, path: "/books/*", min: roleStaff},
+	{method: http.MethodDelete, path: "/books/*", min: roleStaff},
+	{method: http.MethodGet, path: "/books/*/holds", min: roleStaff},
+	{method: http.MethodPost, path: "/books/*/holds", min: rolePatron, unrestricted: true},
+
+	{method: http.MethodGet, path: "/users", min: roleStaff},
+	{method: http.MethodPost, path: "/users", min: roleAdmin},
+	//self-service profile, restricted patrons can still edit it
+	{method: http.MethodPatch, path: "/users/me", min: rolePatron},
+	{method: http.MethodGet, path: "/users/*", min: rolePatron},
+	{method: http.MethodGet, path: "/users/*/holds", min: rolePatron},
+	//restricted patrons can still purge their history
+	{method: http.MethodDelete, path: "/users/*/loan-history", min: rolePatron},
+	//changing a role additionally needs admin, checked in handleSingleUser
+	{method: http.MethodPatch, path: "/users/*", min: roleStaff},
+	{method: http.MethodDelete, path: "/users/*", min: roleAdmin},
+
+	{method: http.MethodPost, path: "/authors", min: roleStaff},
+	{method: http.MethodPatch, path: "/authors/*", min: roleStaff},
+	{method: http.MethodDelete, path: "/authors/*", min: roleStaff},
+	//aliases can include former names, nobody but staff gets to see them
+	{method: http.MethodGet, path: "/authors/*/aliases", min: roleStaff},
+	{method: http.MethodPost, path: "/authors/*/aliases", min: roleStaff},
+	{method: http.MethodDelete, path: "/authors/*/aliases/*", min: roleStaff},
+	{method: http.MethodPut, path: "/books/*/authors", min: roleStaff},
+	{method: http.MethodPut, path: "/books/*/cover", min: roleStaff},
+	{method: http.MethodDelete, path: "/books/*/cover", min: roleStaff},
+	{method: http.MethodPut, path: "/books/*/subjects", min: roleStaff},
+	{method: http.MethodPost, path: "/books/*/tags", min: roleStaff},
+	{method: http.MethodDelete, path: "/books/*/tags/*", min: roleStaff},
+
+	{method: http.MethodGet, path: "/loans", min: rolePatron},
+	{method: http.MethodPost, path: "/loans", min: rolePatron, unrestricted: true},
+	{method: http.MethodPost, path: "/loans/checkout", min: rolePatron, unrestricted: true},
+	{method: http.MethodGet, path: "/loans/*", min: rolePatron},
+	//returns happen at the desk
+	{method: http.MethodPost, path: "/loans/*/return", min: roleStaff},
+	{method: http.MethodPost, path: "/loans/*/renew", min: rolePatron, unrestricted: true},
+
+	{method: http.MethodGet, path: "/holds/*", min: rolePatron},
+
+	//harvesters may POST long OAI-PMH requests, it's still read only
+	{method: http.MethodPost, path: "/oai", min: roleGuest},
+	//patrons can cancel their own, checked in cancelHold
+	{method: http.MethodDelete, path: "/holds/*", min: rolePatron},
+}
+
+// requiredPermission finds the rule for a request. the path is cleaned first, so "/users/" is
+// checked as "/users" (which is how handleUsers treats it too) and a HEAD goes by the GET rules
+func requiredPermission(method, urlPath string) permission {
+	urlPath = path.Clean("/" + urlPath)
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	for _, p := range permissions {
+		if p.method != method {
+			continue
+		}
+		if matchPath(p.path, urlPath) {
+			return p
+		}
+	}
+	if isSafeMethod(method) {
+		return permission{method: method, path: urlPath, min: roleGuest}
+	}
+	return permission{method: method, path: urlPath, min: roleStaff}
+}
+
+// matchPath compares pattern and p segment by segment. unlike path.Match a * never matches an
+// empty segment, "/users/*" doesn't match "/users/"
+func matchPath(pattern, p string) bool {
+	want, got := strings.Split(pattern, "/"), strings.Split(p, "/")
+	if len(want) != len(got) {
+		return false
+	}
+	for i := range want {
+		if want[i] == "*" && got[i] != "" {
+			continue
+		}
+		if want[i] != got[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// authorize looks up the caller's role and checks it against the permissions table
+// before the request reaches a handler. has to sit inside wrapCAS so the caseID is on the context.
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := s.lookupCaller(r.Context())
+		if err != nil {
+			log.Printf("caller lookup failed: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to look up user")
+			return
+		}
+
+		p := requiredPermission(r.Method, r.URL.Path)
+		if c.Role < p.min {
+			if c.CaseID == "" {
+				writeError(w, http.StatusUnauthorized, "authentication required")
+				return
+			}
+			writeError(w, http.StatusForbidden, p.min.String()+" role required")
+			return
+		}
+		if p.unrestricted && c.IsRestricted {
+			writeError(w, http.StatusForbidden, "account is restricted")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey, c)))
+	})
+}
+
+func (s *Server) lookupCaller(ctx context.Context) (caller, error) {
+	caseID, ok := caseIDFrom(ctx)
+	if !ok {
+		return caller{Role: roleGuest}, nil
+	}
+
+	c := caller{CaseID: caseID, Role: roleGuest}
+	var roleName string
+	err := s.db.QueryRowContext(ctx, `
+        SELECT role, isRestricted FROM users WHERE caseID = ?`, caseID,
+	).Scan(&roleName, &c.IsRestricted)
+	if errors.Is(err, sql.ErrNoRows) {
+		//logged in through CAS but nobody has added them as a patron yet
+		return c, nil
+	}
+	if err != nil {
+		return caller{}, err
+	}
+	c.Role, _ = parseRole(roleName)
+	return c, nil
+}
+
+// callerFrom returns the caller authorize attached to the request
+func callerFrom(ctx context.Context) caller {
+	if c, ok := ctx.Value(callerKey).(caller); ok {
+		return c
+	}
+	return caller{Role: roleGuest}
+}
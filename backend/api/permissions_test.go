@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRequiredPermission(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   role
+	}{
+		//the collection with a trailing slash goes by the collection's rules
+		{http.MethodGet, "/users/", roleStaff},
+		{http.MethodHead, "/users/", roleStaff},
+		{http.MethodPost, "/users/", roleAdmin},
+		{http.MethodPut, "/users/", roleStaff},
+		{http.MethodPatch, "/users/", roleStaff},
+		{http.MethodDelete, "/users/", roleStaff},
+		{http.MethodOptions, "/users/", roleGuest},
+
+		{http.MethodGet, "/users", roleStaff},
+		{http.MethodPost, "/users", roleAdmin},
+		{http.MethodGet, "/users/abc123", rolePatron},
+		{http.MethodGet, "/users/abc123/", rolePatron},
+		{http.MethodHead, "/users/abc123", rolePatron},
+		{http.MethodPatch, "/users/me", rolePatron},
+		{http.MethodPatch, "/users/abc123", roleStaff},
+		{http.MethodDelete, "/users/abc123", roleAdmin},
+		{http.MethodGet, "/users/abc123/holds", rolePatron},
+		{http.MethodDelete, "/users/abc123/loan-history", rolePatron},
+
+		{http.MethodGet, "/books", roleGuest},
+		{http.MethodPost, "/books/", roleStaff},
+		{http.MethodGet, "/books/1/holds", roleStaff},
+		{http.MethodPost, "/books/1/holds", rolePatron},
+		{http.MethodGet, "/authors/7/aliases", roleStaff},
+		{http.MethodGet, "/authors/7/aliases/", roleStaff},
+		//unlisted writes need staff, unlisted reads are open
+		{http.MethodPost, "/nothing/here", roleStaff},
+		{http.MethodGet, "/nothing/here", roleGuest},
+	}
+	for _, tt := range tests {
+		if got := requiredPermission(tt.method, tt.path).min; got != tt.want {
+			t.Errorf("%s %s needs %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/users/*", "/users/abc", true},
+		{"/users/*", "/users/", false},
+		{"/users/*", "/users", false},
+		{"/users/*/holds", "/users//holds", false},
+		{"/users/*/holds", "/users/abc/holds", true},
+		{"/users/*/holds", "/users/abc/holds/x", false},
+		{"/books", "/books", true},
+		{"/books", "/booksx", false},
+	}
+	for _, tt := range tests {
+		if got := matchPath(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
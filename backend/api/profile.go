@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxPreferredNameLength = 64 //users.preferredName is varchar(64)
+	maxPronounsLength      = 32 //users.pronouns is varchar(32)
+)
+
+// userColumns is what staff get to see about a patron. preferred name and pronouns come
+// back NULL unless the patron has chosen to share them. scans into scanUser.
+const userColumns = `caseID, role, isRestricted,
+    IF(shareNameWithStaff, preferredName, NULL), IF(sharePronounsWithStaff, pronouns, NULL)`
+
+func scanUser(row interface{ Scan(...any) error }) (user, error) {
+	var u user
+	err := row.Scan(&u.CaseID, &u.Role, &u.IsRestricted, &u.PreferredName, &u.Pronouns)
+	return u, err
+}
+
+// the patron's own view of their record, share flags included
+type profile struct {
+	CaseID                 string  `json:"caseID"`
+	Role                   string  `json:"role"`
+	IsRestricted           bool    `json:"isRestricted"`
+	PreferredName          *string `json:"preferredName"`
+	Pronouns               *string `json:"pronouns"`
+	ShareNameWithStaff     bool    `json:"shareNameWithStaff"`
+	SharePronounsWithStaff bool    `json:"sharePronounsWithStaff"`
+	//opts out of the retention policy, see retention.go
+	KeepLoanHistory bool `json:"keepLoanHistory"`
+}
+
+func (s *Server) profileOf(ctx context.Context, caseID string) (profile, error) {
+	var p profile
+	err := s.db.QueryRowContext(ctx, `
+        SELECT caseID, role, isRestricted, preferredName, pronouns, shareNameWithStaff, sharePronounsWithStaff, keepLoanHistory
+        FROM users WHERE caseID = ?`, caseID,
+	).Scan(&p.CaseID, &p.Role, &p.IsRestricted, &p.PreferredName, &p.Pronouns, &p.ShareNameWithStaff, &p.SharePronounsWithStaff, &p.KeepLoanHistory)
+	if errors.Is(err, sql.ErrNoRows) {
+		return profile{}, errUnknownUser
+	}
+	return p, err
+}
+
+// GET /users/me and PATCH /users/me
+// PATCH has merge semantics: leave a field out to keep it, send null (or "") to clear it
+// EXAMPLE: {"preferredName": "Sam", "pronouns": "they/them", "shareNameWithStaff": true}
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
+	c := callerFrom(r.Context())
+	if c.CaseID == "" {
+		writeError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		var body map[string]json.RawMessage
+		if err := decodeJSON(r, &body); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+
+		var sets []string
+		var args []interface{}
+		for _, field := range []struct {
+			name   string
+			maxLen int
+		}{{"preferredName", maxPreferredNameLength}, {"pronouns", maxPronounsLength}} {
+			raw, ok := body[field.name]
+			if !ok {
+				continue
+			}
+			var value *string
+			if err := json.Unmarshal(raw, &value); err != nil {
+				http.Error(w, field.name+" must be a string or null", http.StatusBadRequest)
+				return
+			}
+			if value != nil {
+				trimmed := strings.TrimSpace(*value)
+				if utf8.RuneCountInString(trimmed) > field.maxLen {
+					http.Error(w, field.name+" is too long", http.StatusBadRequest)
+					return
+				}
+				value = &trimmed
+				if trimmed == "" {
+					value = nil
+				}
+			}
+			sets = append(sets, field.name+" = ?")
+			args = append(args, value)
+		}
+		for _, name := range []string{"shareNameWithStaff", "sharePronounsWithStaff", "keepLoanHistory"} {
+			raw, ok := body[name]
+			if !ok {
+				continue
+			}
+			var value bool
+			if err := json.Unmarshal(raw, &value); err != nil {
+				http.Error(w, name+" must be true or false", http.StatusBadRequest)
+				return
+			}
+			sets = append(sets, name+" = ?")
+			args = append(args, value)
+		}
+		if len(sets) == 0 {
+			http.Error(w, "nothing to update", http.StatusBadRequest)
+			return
+		}
+
+		args = append(args, c.CaseID)
+		if _, err := s.db.ExecContext(r.Context(),
+			`UPDATE users SET `+strings.Join(sets, ", ")+` WHERE caseID = ?`, args...,
+		); err != nil {
+			http.Error(w, "update failed", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p, err := s.profileOf(r.Context(), c.CaseID)
+	if errors.Is(err, errUnknownUser) {
+		http.Error(w, "not registered with the library", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "query failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// retentionPolicy is how long we hold on to who borrowed what. loans are the sensitive part of
+// running this library, so once a loan is returned and the period is over the row keeps its dates
+// (and the book keeps its loanMetrics) but the caseID is dropped. closed holds are deleted outright.
+// patrons who set keepLoanHistory are skipped until they turn it off again.
+type retentionPolicy struct {
+	//days after the return date, 0 anonymizes on the next maintenance run
+	LoanDays int
+}
+
+const defaultRetentionDays = 30
+
+var errBadRetention = errors.New("CATALOG_LOAN_RETENTION_DAYS must be a whole number of days")
+
+// retentionFromEnv reads CATALOG_LOAN_RETENTION_DAYS, falling back to defaultRetentionDays
+func retentionFromEnv() (retentionPolicy, error) {
+	raw := os.Getenv("CATALOG_LOAN_RETENTION_DAYS")
+	if raw == "" {
+		return retentionPolicy{LoanDays: defaultRetentionDays}, nil
+	}
+	days, err := strconv.Atoi(raw)
+	if err != nil || days < 0 {
+		return retentionPolicy{}, errBadRetention
+	}
+	return retentionPolicy{LoanDays: days}, nil
+}
+
+// anonymizeHistory applies the retention policy to everyone who hasn't opted out, returns how many loans it anonymized
+func (s *Server) anonymizeHistory(ctx context.Context) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+        UPDATE loan l JOIN users u ON u.caseID = l.caseID
+        SET l.caseID = NULL
+        WHERE l.returnDate IS NOT NULL
+          AND l.returnDate <= DATE_SUB(CURDATE(), INTERVAL ? DAY)
+          AND NOT u.keepLoanHistory`, s.retention.LoanDays,
+	)
+	if err != nil {
+		return 0, err
+	}
+	anonymized, _ := res.RowsAffected()
+
+	//holds don't record when they closed, the last thing that happened to them is close enough
+	if _, err := tx.ExecContext(ctx, `
+        DELETE h FROM holds h JOIN users u ON u.caseID = h.caseID
+        WHERE h.status IN ('fulfilled', 'cancelled', 'expired')
+          AND COALESCE(h.expiresAt, h.readyAt, h.placedAt) <= DATE_SUB(NOW(), INTERVAL ? DAY)
+          AND NOT u.keepLoanHistory`, s.retention.LoanDays,
+	); err != nil {
+		return 0, err
+	}
+	return anonymized, tx.Commit()
+}
+
+// purgeHistory anonymizes every returned loan and deletes every closed hold of one patron right now,
+// whatever their keepLoanHistory setting. loans still out are kept, we need to know who has the book.
+func (s *Server) purgeHistory(ctx context.Context, caseID string) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+        UPDATE loan SET caseID = NULL WHERE caseID = ? AND returnDate IS NOT NULL`, caseID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	anonymized, _ := res.RowsAffected()
+
+	if _, err := tx.ExecContext(ctx, `
+        DELETE FROM holds WHERE caseID = ? AND status IN ('fulfilled', 'cancelled', 'expired')`, caseID,
+	); err != nil {
+		return 0, err
+	}
+	return anonymized, tx.Commit()
+}
+
+// DELETE /users/{caseID}/loan-history, or /users/me/loan-history, purges a patron's history immediately.
+// to keep history instead, PATCH /users/me {"keepLoanHistory": true}
+func (s *Server) handleLoanHistory(w http.ResponseWriter, r *http.Request, caseID string) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !callerFrom(r.Context()).canActFor(caseID) {
+		writeError(w, http.StatusForbidden, errNotYourRecord.Error())
+		return
+	}
+
+	anonymized, err := s.purgeHistory(r.Context(), caseID)
+	if err != nil {
+		log.Printf("purge loan history: %v", err)
+		http.Error(w, "purge failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"anonymized": anonymized})
+}
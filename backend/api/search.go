@@ -0,0 +1,320 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bxb454/csds-395-lgbt-library-catalog/search"
+)
+
+// how far back each index sync looks past the previous one. a transaction can set updatedAt
+// before a sync and only commit after it, the overlap picks those up on the next one
+const indexSyncSlack = time.Minute
+
+// below this many hits /search also offers a respelled query
+const sparseResults = 5
+
+// searchResult is a book from /search with its relevance score
+type searchResult struct {
+	book
+	Score float64 `json:"score"`
+}
+
+// loadSearchDocs reads what the index needs for the books matching where (a condition on books).
+// authors come with their searchable aliases and subjects with the labels of every broader heading,
+// so searching a broad term also finds books filed under the narrower ones
+func (s *Server) loadSearchDocs(ctx context.Context, where string, args ...any) ([]search.Doc, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT bookID, title, COALESCE(publisher, ''), COALESCE(YEAR(pubdate), 0) FROM books WHERE `+where, args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var docs []search.Doc
+	byID := make(map[int]*search.Doc)
+	for rows.Next() {
+		var d search.Doc
+		if err := rows.Scan(&d.ID, &d.Title, &d.Publisher, &d.Year); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		docs = append(docs, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range docs {
+		byID[docs[i].ID] = &docs[i]
+	}
+
+	inBooks := `bookID IN (SELECT bookID FROM books WHERE ` + where + `)`
+	err = s.scanBookValues(ctx, `
+        SELECT ba.bookID, CONCAT_WS(' ', a.fname, a.lname) FROM bookAuthor ba
+        JOIN authors a ON a.authID = ba.authID WHERE ba.`+inBooks,
+		args, func(d *search.Doc, v string) { d.Authors = append(d.Authors, v) }, byID)
+	if err != nil {
+		return nil, err
+	}
+	//aliases get their own field, they find the book but never come back out of /suggest or didYouMean
+	err = s.scanBookValues(ctx, `
+        SELECT ba.bookID, CONCAT_WS(' ', al.fname, al.lname) FROM bookAuthor ba
+        JOIN authorAliases al ON al.authID = ba.authID WHERE al.searchable AND ba.`+inBooks,
+		args, func(d *search.Doc, v string) { d.AuthorAliases = append(d.AuthorAliases, v) }, byID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.scanBookValues(ctx, `SELECT bookID, authID FROM bookAuthor WHERE `+inBooks,
+		args, func(d *search.Doc, v string) {
+			if id, err := strconv.Atoi(v); err == nil {
+				d.AuthorIDs = append(d.AuthorIDs, id)
+			}
+		}, byID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.scanBookValues(ctx, `SELECT bookID, tag FROM booktags WHERE `+inBooks,
+		args, func(d *search.Doc, v string) { d.Tags = append(d.Tags, v) }, byID)
+	if err != nil {
+		return nil, err
+	}
+	//UNION (not UNION ALL) keeps the walk up subjectRelations from looping on a cycle
+	err = s.scanBookValues(ctx, `
+        WITH RECURSIVE headings (bookID, uri) AS (
+            SELECT bookID, uri FROM bookSubjects WHERE `+inBooks+`
+            UNION
+            SELECT h.bookID, r.broader FROM headings h JOIN subjectRelations r ON r.narrower = h.uri
+        )
+        SELECT h.bookID, s.prefLabel FROM headings h JOIN subjects s ON s.uri = h.uri
+        UNION ALL
+        SELECT h.bookID, al.label FROM headings h JOIN subjectAltLabels al ON al.uri = h.uri`,
+		args, func(d *search.Doc, v string) { d.Subjects = append(d.Subjects, v) }, byID)
+	if err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// scanBookValues reads (bookID, text) rows into the docs they belong to
+func (s *Server) scanBookValues(ctx context.Context, query string, args []any, add func(*search.Doc, string), docs map[int]*search.Doc) error {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bookID int
+		var value string
+		if err := rows.Scan(&bookID, &value); err != nil {
+			return err
+		}
+		if d, ok := docs[bookID]; ok {
+			add(d, value)
+		}
+	}
+	return rows.Err()
+}
+
+// rebuildIndex loads every book into the search index from scratch
+func (s *Server) rebuildIndex(ctx context.Context) error {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	var now time.Time
+	if err := s.db.QueryRowContext(ctx, `SELECT NOW()`).Scan(&now); err != nil {
+		return err
+	}
+	docs, err := s.loadSearchDocs(ctx, `TRUE`)
+	if err != nil {
+		return err
+	}
+	s.index.Replace(docs)
+	s.indexedAt = now
+	return nil
+}
+
+// syncIndex reindexes the books that changed since the last sync (by updatedAt, see touchBooks) and
+// drops the deleted ones. writes from other processes, like import-books, get picked up too
+func (s *Server) syncIndex(ctx context.Context) error {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	//the command line tools never build an index, nothing to keep in sync
+	if s.indexedAt.IsZero() {
+		return nil
+	}
+
+	var now time.Time
+	if err := s.db.QueryRowContext(ctx, `SELECT NOW()`).Scan(&now); err != nil {
+		return err
+	}
+	since := s.indexedAt.Add(-indexSyncSlack)
+	docs, err := s.loadSearchDocs(ctx, `updatedAt >= ?`, since)
+	if err != nil {
+		return err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT bookID FROM deletedBooks WHERE deletedAt >= ?`, since)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var deleted []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		deleted = append(deleted, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.index.Delete(deleted...)
+	s.index.Put(docs...)
+	s.indexedAt = now
+	return nil
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// syncAfterWrites brings the search index up to date after every write that went through,
+// so whoever made the change sees it in their next search
+func (s *Server) syncAfterWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//an OAI-PMH POST is a harvest, it doesn't change anything
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.URL.Path == "/oai" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status < 400 {
+			if err := s.syncIndex(r.Context()); err != nil {
+				log.Printf("search index sync failed: %v", err)
+			}
+		}
+	})
+}
+
+// searchBooks ranks the catalog against q and loads one page of the hits, best first
+func (s *Server) searchBooks(ctx context.Context, q string, pagination PaginationParams) ([]searchResult, int, error) {
+	return s.loadHits(ctx, s.index.Search(q), pagination)
+}
+
+// loadHits loads one page of hits as books, in the order given
+func (s *Server) loadHits(ctx context.Context, hits []search.Hit, pagination PaginationParams) ([]searchResult, int, error) {
+	total := len(hits)
+	if pagination.Offset >= total {
+		return nil, total, nil
+	}
+	hits = hits[pagination.Offset:min(pagination.Offset+pagination.Limit, total)]
+
+	ids := make([]int, len(hits))
+	for i, h := range hits {
+		ids[i] = h.ID
+	}
+	books, _, err := s.queryBooksWithFilters(ctx, BookFilters{IDs: ids}, PaginationParams{Limit: len(ids)})
+	if err != nil {
+		return nil, 0, err
+	}
+	byID := make(map[int]book, len(books))
+	for _, b := range books {
+		byID[b.ID] = b
+	}
+	var results []searchResult
+	for _, h := range hits {
+		//deleted since the last sync
+		if b, ok := byID[h.ID]; ok {
+			results = append(results, searchResult{book: b, Score: h.Score})
+		}
+	}
+	return results, total, nil
+}
+
+// relevance ranked search over titles, author names (searchable aliases included), tags, subject
+// headings and publishers. every word has to match somewhere, "trans memoir" finds a memoir tagged trans.
+// words are stemmed, so "memoirs" finds "memoir" too. a word the catalog doesn't have at all matches
+// title, author and tag words a typo or two off, and with few hits the response gets a "didYouMean".
+// q can also use the query syntax in search/query.go: author:lorde tag:poetry year:1980..1990
+// -tag:fiction "sister outsider", (zami OR unicorn). a query that doesn't parse is a 400 with
+// {"error", "token", "offset"} pointing at where it went wrong. best match first unless ?sort= says
+// otherwise, it takes the /books names plus score, like sort=-popularity,-score
+// the response also has "facets": counts of tags, authors, publishers, decades and availability over
+// the hits, and ?tag=&authorID=&publisher=&decade=&available= narrow the hits down (see facetSelection)
+// EXAMPLE: GET /api/v1/search?q=stone+butch&limit=5&offset=10
+// EXAMPLE: GET /api/v1/search?q=poetry&tag=lesbian&decade=1980&decade=1990&available=true
+func (s *Server) handleSearch() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query == "" {
+			http.Error(w, "missing search query", http.StatusBadRequest)
+			return
+		}
+
+		parsed, err := search.Parse(query)
+		if err != nil {
+			var syntaxErr *search.SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, http.StatusBadRequest, map[string]any{
+				"error":  syntaxErr.Message,
+				"token":  syntaxErr.Token,
+				"offset": syntaxErr.Offset,
+			})
+			return
+		}
+		sel, err := parseFacetSelection(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		keys, err := parseSort(r.URL.Query().Get("sort"), sortNames(bookSortColumns, "score"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		pagination := parsePagination(r)
+		matched := s.index.Find(parsed)
+		hits, facets, err := s.facetHits(r.Context(), matched, sel)
+		if err != nil {
+			http.Error(w, "search query failed", http.StatusInternalServerError)
+			return
+		}
+		if err := s.sortHits(r.Context(), hits, keys); err != nil {
+			http.Error(w, "search query failed", http.StatusInternalServerError)
+			return
+		}
+		results, total, err := s.loadHits(r.Context(), hits, pagination)
+		if err != nil {
+			http.Error(w, "search query failed", http.StatusInternalServerError)
+			return
+		}
+		resp := paginatedResponse(nonNil(results), pagination, total)
+		resp["facets"] = facets
+		if len(matched) < sparseResults {
+			if suggestion := s.index.Suggest(query); suggestion != "" {
+				resp["didYouMean"] = suggestion
+			}
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
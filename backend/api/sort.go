@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bxb454/csds-395-lgbt-library-catalog/search"
+)
+
+// sortKey is one entry of ?sort=, "-title" is title descending
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// sortColumn is what a ?sort= name orders by. exprs are fixed sql, never from the request
+type sortColumn struct {
+	exprs []string
+	//NULLs go last whichever way it's sorted
+	nullable bool
+}
+
+// ?sort= names for GET /books and /search. popularity is loanMetrics, dateAdded is createdAt,
+// both go by either name
+var bookSortColumns = map[string]sortColumn{
+	"id":          {exprs: []string{"bookID"}},
+	"title":       {exprs: []string{"title"}},
+	"popularity":  {exprs: []string{"loanMetrics"}},
+	"loanMetrics": {exprs: []string{"loanMetrics"}},
+	"pubdate":     {exprs: []string{"pubdate"}, nullable: true},
+	"dateAdded":   {exprs: []string{"createdAt"}},
+	"createdAt":   {exprs: []string{"createdAt"}},
+}
+
+// ?sort= names for GET /authors. books is how many books they have, popularity the loans of those books.
+// fname can be NULL, so name is nullable too, lname IS NULL is just always false
+var authorSortColumns = map[string]sortColumn{
+	"id":    {exprs: []string{"authID"}},
+	"name":  {exprs: []string{"lname", "fname"}, nullable: true},
+	"lname": {exprs: []string{"lname"}},
+	"fname": {exprs: []string{"fname"}, nullable: true},
+	"books": {exprs: []string{`(SELECT COUNT(*) FROM bookAuthor ba WHERE ba.authID = authors.authID)`}},
+	"popularity": {exprs: []string{`(
+        SELECT COALESCE(SUM(b.loanMetrics), 0) FROM bookAuthor ba JOIN books b ON b.bookID = ba.bookID
+        WHERE ba.authID = authors.authID)`}},
+}
+
+// parseSort reads a comma separated ?sort= list like "-loanMetrics,title" against the names a
+// list allows. "" is no keys, the list's usual order
+func parseSort(raw string, allowed []string) ([]sortKey, error) {
+	var keys []sortKey
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key := sortKey{field: part}
+		if strings.HasPrefix(part, "-") {
+			key = sortKey{field: part[1:], desc: true}
+		} else if strings.HasPrefix(part, "+") {
+			key.field = part[1:]
+		}
+		if !contains(allowed, key.field) {
+			return nil, fmt.Errorf("can't sort by %q, use %s", key.field, strings.Join(allowed, ", "))
+		}
+		if seen[key.field] {
+			return nil, fmt.Errorf("%q is in sort twice", key.field)
+		}
+		seen[key.field] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func sortNames(columns map[string]sortColumn, extra ...string) []string {
+	names := append([]string{}, extra...)
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// orderBySQL turns keys into an ORDER BY list, with tiebreak (a unique column) last so rows
+// that sort the same always come back in the same order and pages don't overlap
+func orderBySQL(keys []sortKey, columns map[string]sortColumn, tiebreak string) string {
+	var parts []string
+	for _, k := range keys {
+		col := columns[k.field]
+		for _, expr := range col.exprs {
+			if col.nullable {
+				parts = append(parts, expr+` IS NULL`)
+			}
+			if k.desc {
+				expr += ` DESC`
+			}
+			parts = append(parts, expr)
+		}
+	}
+	return strings.Join(append(parts, tiebreak), ", ")
+}
+
+// bookSortValues is what sortHits compares search hits on
+type bookSortValues struct {
+	loanMetrics int
+	title       string //folded, so it sorts about the way mysql's collation does
+	pubdate     sql.NullTime
+	createdAt   time.Time
+}
+
+// sortHits reorders search hits by keys, which can also have "score". ties go by bookID like /books
+func (s *Server) sortHits(ctx context.Context, hits []search.Hit, keys []sortKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	const chunk = 1000
+	values := make(map[int]bookSortValues, len(hits))
+	for start := 0; start < len(hits); start += chunk {
+		ids := make([]int, 0, chunk)
+		for _, h := range hits[start:min(start+chunk, len(hits))] {
+			ids = append(ids, h.ID)
+		}
+		placeholders, args := inClause(ids)
+		rows, err := s.db.QueryContext(ctx, `
+            SELECT bookID, loanMetrics, title, pubdate, createdAt FROM books WHERE bookID IN (`+placeholders+`)`, args...,
+		)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int
+			var v bookSortValues
+			if err := rows.Scan(&id, &v.loanMetrics, &v.title, &v.pubdate, &v.createdAt); err != nil {
+				rows.Close()
+				return err
+			}
+			v.title = search.Fold(v.title)
+			values[id] = v
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		a, b := values[hits[i].ID], values[hits[j].ID]
+		for _, k := range keys {
+			var c int
+			switch k.field {
+			case "score":
+				c = cmpFloat(hits[i].Score, hits[j].Score)
+			case "id":
+				c = hits[i].ID - hits[j].ID
+			case "title":
+				c = strings.Compare(a.title, b.title)
+			case "popularity", "loanMetrics":
+				c = a.loanMetrics - b.loanMetrics
+			case "dateAdded", "createdAt":
+				c = a.createdAt.Compare(b.createdAt)
+			case "pubdate":
+				//no date goes last either way, like IS NULL in orderBySQL
+				if a.pubdate.Valid != b.pubdate.Valid {
+					return a.pubdate.Valid
+				}
+				c = a.pubdate.Time.Compare(b.pubdate.Time)
+			}
+			if k.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	return nil
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
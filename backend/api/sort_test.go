@@ -0,0 +1,52 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSort(t *testing.T) {
+	allowed := sortNames(bookSortColumns, "bookID", "score")
+	tests := []struct {
+		raw     string
+		want    []sortKey
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"title", []sortKey{{field: "title"}}, false},
+		{"-loanMetrics, +title", []sortKey{{field: "loanMetrics", desc: true}, {field: "title"}}, false},
+		{"score,,-pubdate", []sortKey{{field: "score"}, {field: "pubdate", desc: true}}, false},
+		{"isbn", nil, true},
+		{"title,-title", nil, true},
+		{"--title", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSort(tt.raw, allowed)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSort(%q) = %v, %v, want %v (error %v)", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestOrderBySQL(t *testing.T) {
+	tests := []struct {
+		keys     []sortKey
+		columns  map[string]sortColumn
+		tiebreak string
+		want     string
+	}{
+		{nil, bookSortColumns, "bookID", "bookID"},
+		{[]sortKey{{field: "title"}}, bookSortColumns, "bookID", "title, bookID"},
+		{[]sortKey{{field: "pubdate", desc: true}, {field: "title"}}, bookSortColumns, "bookID", "pubdate IS NULL, pubdate DESC, title, bookID"},
+		//authors without a first name go last either way
+		{[]sortKey{{field: "fname"}}, authorSortColumns, "authID", "fname IS NULL, fname, authID"},
+		{[]sortKey{{field: "fname", desc: true}}, authorSortColumns, "authID", "fname IS NULL, fname DESC, authID"},
+		{[]sortKey{{field: "name", desc: true}}, authorSortColumns, "authID", "lname IS NULL, lname DESC, fname IS NULL, fname DESC, authID"},
+		{[]sortKey{{field: "lname"}}, authorSortColumns, "authID", "lname, authID"},
+	}
+	for _, tt := range tests {
+		if got := orderBySQL(tt.keys, tt.columns, tt.tiebreak); got != tt.want {
+			t.Errorf("orderBySQL(%v) = %q, want %q", tt.keys, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/bxb454/csds-395-lgbt-library-catalog/homosaurus"
+)
+
+const maxLabelLength = 255 //subjects.prefLabel and subjectAltLabels.label are varchar(255)
+
+var errUnknownSubject = errors.New("unknown subject uri")
+
+// ImportSubjects loads a parsed Homosaurus dump. it's safe to run again on a newer release,
+// labels and relations of every imported term get replaced and existing book assignments are kept.
+func (s *Server) ImportSubjects(ctx context.Context, terms []homosaurus.Term) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, t := range terms {
+		res, err := tx.ExecContext(ctx, `
+            INSERT INTO subjects (uri, prefLabel) VALUES (?, ?)
+            ON DUPLICATE KEY UPDATE prefLabel = VALUES(prefLabel)`,
+			t.URI, truncateRunes(t.PrefLabel, maxLabelLength),
+		)
+		if err != nil {
+			return 0, err
+		}
+		//2 rows affected means an existing heading got a new label
+		if n, _ := res.RowsAffected(); n == 2 {
+			if err := touchBooks(ctx, tx, `bookID IN (SELECT bookID FROM bookSubjects WHERE uri = ?)`, t.URI); err != nil {
+				return 0, err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM subjectAltLabels WHERE uri = ?`, t.URI); err != nil {
+			return 0, err
+		}
+		for _, alt := range t.AltLabels {
+			if _, err := tx.ExecContext(ctx, `
+                INSERT IGNORE INTO subjectAltLabels (uri, label) VALUES (?, ?)`,
+				t.URI, truncateRunes(alt, maxLabelLength),
+			); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	//relations go in a second pass once every concept exists. links to concepts that aren't
+	//in the dump or the table (deprecated terms etc) are skipped rather than failing the import
+	for _, t := range terms {
+		if _, err := tx.ExecContext(ctx, `
+            DELETE FROM subjectRelations WHERE broader = ? OR narrower = ?`, t.URI, t.URI,
+		); err != nil {
+			return 0, err
+		}
+	}
+	addRelation := func(broader, narrower string) error {
+		_, err := tx.ExecContext(ctx, `
+            INSERT IGNORE INTO subjectRelations (broader, narrower)
+            SELECT ?, ? FROM DUAL
+            WHERE EXISTS(SELECT 1 FROM subjects WHERE uri = ?) AND EXISTS(SELECT 1 FROM subjects WHERE uri = ?)`,
+			broader, narrower, broader, narrower,
+		)
+		return err
+	}
+	for _, t := range terms {
+		for _, b := range t.Broader {
+			if err := addRelation(b, t.URI); err != nil {
+				return 0, err
+			}
+		}
+		for _, n := range t.Narrower {
+			if err := addRelation(t.URI, n); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return len(terms), tx.Commit()
+}
+
+// GET /subjects?q=&limit=&offset= searches preferred and alternate labels
+func (s *Server) handleSubjects() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		pagination := parsePagination(r)
+
+		whereClause := ""
+		var args []interface{}
+		if q := r.URL.Query().Get("q"); q != "" {
+			whereClause = ` WHERE s.prefLabel LIKE ? OR s.uri IN (SELECT uri FROM subjectAltLabels WHERE label LIKE ?)`
+			args = append(args, "%"+q+"%", "%"+q+"%")
+		}
+
+		var total int
+		if err := s.db.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM subjects s`+whereClause, args...).Scan(&total); err != nil {
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+
+		rows, err := s.db.QueryContext(r.Context(), `
+            SELECT s.uri, s.prefLabel FROM subjects s`+whereClause+`
+            ORDER BY s.prefLabel, s.uri LIMIT ? OFFSET ?`,
+			append(args, pagination.Limit, pagination.Offset)...,
+		)
+		if err != nil {
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		var result []subject
+		for rows.Next() {
+			var sub subject
+			if err := rows.Scan(&sub.URI, &sub.PrefLabel); err != nil {
+				http.Error(w, "scan failed", http.StatusInternalServerError)
+				return
+			}
+			result = append(result, sub)
+		}
+		writeJSON(w, http.StatusOK, paginatedResponse(result, pagination, total))
+	})
+}
+
+// GET /subjects/detail?uri=https://homosaurus.org/v3/homoit0000001
+// uris don't fit in a path segment so this one takes a query param
+func (s *Server) handleSubjectDetail() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uri := r.URL.Query().Get("uri")
+		if uri == "" {
+			http.Error(w, "missing uri", http.StatusBadRequest)
+			return
+		}
+
+		type detail struct {
+			subject
+			AltLabels []string  `json:"altLabels"`
+			Broader   []subject `json:"broader"`
+			Narrower  []subject `json:"narrower"`
+		}
+		var d detail
+		err := s.db.QueryRowContext(r.Context(), `SELECT uri, prefLabel FROM subjects WHERE uri = ?`, uri).Scan(&d.URI, &d.PrefLabel)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+
+		alts, err := s.db.QueryContext(r.Context(), `SELECT label FROM subjectAltLabels WHERE uri = ? ORDER BY label`, uri)
+		if err != nil {
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		defer alts.Close()
+		for alts.Next() {
+			var l string
+			if err := alts.Scan(&l); err != nil {
+				http.Error(w, "scan failed", http.StatusInternalServerError)
+				return
+			}
+			d.AltLabels = append(d.AltLabels, l)
+		}
+
+		if d.Broader, err = s.relatedSubjects(r.Context(), `r.narrower = ? AND s.uri = r.broader`, uri); err != nil {
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		if d.Narrower, err = s.relatedSubjects(r.Context(), `r.broader = ? AND s.uri = r.narrower`, uri); err != nil {
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		d.AltLabels, d.Broader, d.Narrower = nonNil(d.AltLabels), nonNil(d.Broader), nonNil(d.Narrower)
+		writeJSON(w, http.StatusOK, d)
+	})
+}
+
+func (s *Server) relatedSubjects(ctx context.Context, join string, uri string) ([]subject, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT s.uri, s.prefLabel FROM subjectRelations r JOIN subjects s ON `+join+`
+        ORDER BY s.prefLabel`, uri,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []subject
+	for rows.Next() {
+		var sub subject
+		if err := rows.Scan(&sub.URI, &sub.PrefLabel); err != nil {
+			return nil, err
+		}
+		result = append(result, sub)
+	}
+	return result, rows.Err()
+}
+
+// GET /books/{id}/subjects, and PUT to replace them: {"uris": ["https://homosaurus.org/v3/homoit0000001"]}
+func (s *Server) handleBookSubjects(w http.ResponseWriter, r *http.Request, id string) {
+	bookID, err := strconv.Atoi(id)
+	if err != nil {
+		http.Error(w, "invalid book id", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		type payload struct {
+			URIs []string `json:"uris"`
+		}
+		var body payload
+		if err := decodeJSON(r, &body); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := s.setBookSubjects(r.Context(), bookID, body.URIs); err != nil {
+			switch {
+			case errors.Is(err, errBookNotFound):
+				http.NotFound(w, r)
+			case errors.Is(err, errUnknownSubject):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				log.Printf("set book subjects: %v", err)
+				http.Error(w, "update failed", http.StatusInternalServerError)
+			}
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var exists bool
+	s.db.QueryRowContext(r.Context(), `SELECT EXISTS(SELECT 1 FROM books WHERE bookID = ?)`, bookID).Scan(&exists)
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	subjects, err := s.subjectsForBooks(r.Context(), []int{bookID})
+	if err != nil {
+		http.Error(w, "query failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(subjects[bookID]))
+}
+
+func (s *Server) setBookSubjects(ctx context.Context, bookID int, uris []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked int
+	err = tx.QueryRowContext(ctx, `SELECT bookID FROM books WHERE bookID = ? FOR UPDATE`, bookID).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errBookNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM bookSubjects WHERE bookID = ?`, bookID); err != nil {
+		return err
+	}
+	for _, uri := range uris {
+		var found bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM subjects WHERE uri = ?)`, uri).Scan(&found); err != nil {
+			return err
+		}
+		if !found {
+			return errUnknownSubject
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT IGNORE INTO bookSubjects (bookID, uri) VALUES (?, ?)`, bookID, uri); err != nil {
+			return err
+		}
+	}
+	if err := touchBooks(ctx, tx, `bookID = ?`, bookID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// subjectsForBooks loads the subjects of a page of books in one query, keyed by bookID
+func (s *Server) subjectsForBooks(ctx context.Context, bookIDs []int) (map[int][]subject, error) {
+	result := make(map[int][]subject)
+	if len(bookIDs) == 0 {
+		return result, nil
+	}
+
+	placeholders, args := inClause(bookIDs)
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT bs.bookID, s.uri, s.prefLabel
+        FROM bookSubjects bs JOIN subjects s ON s.uri = bs.uri
+        WHERE bs.bookID IN (`+placeholders+`)
+        ORDER BY s.prefLabel`, args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID int
+		var sub subject
+		if err := rows.Scan(&bookID, &sub.URI, &sub.PrefLabel); err != nil {
+			return nil, err
+		}
+		result[bookID] = append(result[bookID], sub)
+	}
+	return result, rows.Err()
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
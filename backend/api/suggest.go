@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultSuggestions = 8
+	maxSuggestions     = 20
+)
+
+// search box autocomplete: titles, author names and tags with a word starting with q, whole phrase
+// matches first, then the ones on the most books. author aliases are never offered. answered from
+// the search index, so it never touches mysql and it's as current as /search
+// EXAMPLE: GET /api/v1/suggest?q=audre+lo&limit=5
+func (s *Server) handleSuggest() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		limit := defaultSuggestions
+		if l := r.URL.Query().Get("limit"); l != "" {
+			parsed, err := strconv.Atoi(l)
+			if err != nil || parsed <= 0 || parsed > maxSuggestions {
+				http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxSuggestions), http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+		writeJSON(w, http.StatusOK, nonNil(s.index.Complete(r.URL.Query().Get("q"), limit)))
+	})
+}
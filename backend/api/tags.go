@@ -0,0 +1,304 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const maxTagLength = 128 //booktags.tag is varchar(128)
+
+var errInvalidTag = errors.New("invalid tag")
+
+// normalizeTag is the canonical form every tag is stored and looked up in, so "Trans", "trans "
+// and a decomposed "é" all end up as the same tag. NFKC also folds fullwidth and other lookalike forms.
+// tags go in url paths (/tags/{tag}/books), so a "/" is refused, it could never be looked up
+func normalizeTag(raw string) (string, error) {
+	tag := strings.ToLower(norm.NFKC.String(raw))
+	tag = strings.Join(strings.Fields(tag), " ")
+	if tag == "" {
+		return "", fmt.Errorf("%w: tag is empty", errInvalidTag)
+	}
+	if strings.Contains(tag, "/") {
+		return "", fmt.Errorf("%w: tags can't contain \"/\", use \"-\" instead", errInvalidTag)
+	}
+	if utf8.RuneCountInString(tag) > maxTagLength {
+		return "", fmt.Errorf("%w: tags can be at most %d characters", errInvalidTag, maxTagLength)
+	}
+	return tag, nil
+}
+
+// slashFree swaps "/" for "-" in text that's turned into a tag without anyone to ask,
+// like an old tag or a heading label, normalizeTag would refuse it otherwise
+func slashFree(s string) string {
+	return strings.ReplaceAll(s, "/", "-")
+}
+
+// GET /tags?q=&limit=&offset= is the tag cloud, most used first
+func (s *Server) handleTags() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		pagination := parsePagination(r)
+
+		whereClause := ""
+		var args []interface{}
+		if q := r.URL.Query().Get("q"); q != "" {
+			whereClause = ` WHERE tag LIKE ?`
+			args = append(args, "%"+q+"%")
+		}
+
+		var total int
+		if err := s.db.QueryRowContext(r.Context(), `SELECT COUNT(DISTINCT tag) FROM booktags`+whereClause, args...).Scan(&total); err != nil {
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+
+		rows, err := s.db.QueryContext(r.Context(), `
+            SELECT tag, COUNT(*) AS uses FROM booktags`+whereClause+`
+            GROUP BY tag ORDER BY uses DESC, tag LIMIT ? OFFSET ?`,
+			append(args, pagination.Limit, pagination.Offset)...,
+		)
+		if err != nil {
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		type tagCount struct {
+			Tag   string `json:"tag"`
+			Count int    `json:"count"`
+		}
+		var result []tagCount
+		for rows.Next() {
+			var t tagCount
+			if err := rows.Scan(&t.Tag, &t.Count); err != nil {
+				http.Error(w, "scan failed", http.StatusInternalServerError)
+				return
+			}
+			result = append(result, t)
+		}
+		writeJSON(w, http.StatusOK, paginatedResponse(result, pagination, total))
+	})
+}
+
+// GET /tags/{tag}/books, same shape and filters as GET /books
+func (s *Server) handleTagByName() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/tags/"), "/")
+		if sub != "books" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		tag, err := normalizeTag(raw)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		pagination := parsePagination(r)
+		filters := parseBookFilters(r)
+		filters.Tag = tag
+
+		books, total, err := s.queryBooksWithFilters(r.Context(), filters, pagination)
+		if err != nil {
+			http.Error(w, "query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, paginatedResponse(books, pagination, total))
+	})
+}
+
+// GET /books/{id}/tags, POST {"tags": ["Trans", "memoir"]} to add, DELETE /books/{id}/tags/{tag} to remove
+func (s *Server) handleBookTags(w http.ResponseWriter, r *http.Request, id string, rawTag string) {
+	bookID, err := strconv.Atoi(id)
+	if err != nil {
+		http.Error(w, "invalid book id", http.StatusBadRequest)
+		return
+	}
+	var exists bool
+	if err := s.db.QueryRowContext(r.Context(), `SELECT EXISTS(SELECT 1 FROM books WHERE bookID = ?)`, bookID).Scan(&exists); err != nil {
+		http.Error(w, "query failed", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case rawTag == "" && r.Method == http.MethodGet:
+
+	case rawTag == "" && r.Method == http.MethodPost:
+		type payload struct {
+			Tags []string `json:"tags"`
+		}
+		var body payload
+		if err := decodeJSON(r, &body); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if len(body.Tags) == 0 {
+			http.Error(w, "missing required fields", http.StatusBadRequest)
+			return
+		}
+		tags := make([]string, 0, len(body.Tags))
+		for _, raw := range body.Tags {
+			tag, err := normalizeTag(raw)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			tags = append(tags, tag)
+		}
+		if err := s.addBookTags(r.Context(), bookID, tags); err != nil {
+			log.Printf("add tags: %v", err)
+			http.Error(w, "insert failed", http.StatusInternalServerError)
+			return
+		}
+
+	case rawTag != "" && r.Method == http.MethodDelete:
+		tag, err := normalizeTag(rawTag)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res, err := s.db.ExecContext(r.Context(), `DELETE FROM booktags WHERE bookID = ? AND tag = ?`, bookID, tag)
+		if err != nil {
+			http.Error(w, "delete failed", http.StatusInternalServerError)
+			return
+		}
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if err := touchBooks(r.Context(), s.db, `bookID = ?`, bookID); err != nil {
+			log.Printf("touch book %d: %v", bookID, err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tags, err := s.tagsForBooks(r.Context(), []int{bookID})
+	if err != nil {
+		http.Error(w, "query failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(tags[bookID]))
+}
+
+// addBookTags expects tags that already went through normalizeTag, ones the book already has are skipped
+func (s *Server) addBookTags(ctx context.Context, bookID int, tags []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, `INSERT IGNORE INTO booktags (bookID, tag) VALUES (?, ?)`, bookID, tag); err != nil {
+			return err
+		}
+	}
+	if err := touchBooks(ctx, tx, `bookID = ?`, bookID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// tagsForBooks loads the tags of a page of books in one query, keyed by bookID
+func (s *Server) tagsForBooks(ctx context.Context, bookIDs []int) (map[int][]string, error) {
+	result := make(map[int][]string)
+	if len(bookIDs) == 0 {
+		return result, nil
+	}
+
+	placeholders, args := inClause(bookIDs)
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT bookID, tag FROM booktags WHERE bookID IN (`+placeholders+`) ORDER BY tag`, args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID int
+		var tag string
+		if err := rows.Scan(&bookID, &tag); err != nil {
+			return nil, err
+		}
+		result[bookID] = append(result[bookID], tag)
+	}
+	return result, rows.Err()
+}
+
+// NormalizeTags rewrites every booktags row into its normalized form, merging tags that only
+// differed by case, spacing or unicode form. run it once on a catalog that predates normalizeTag.
+// a "/" becomes "-" (see slashFree), "bi/pan" is kept as "bi-pan" rather than dropped
+func (s *Server) NormalizeTags(ctx context.Context) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT bookID, tag FROM booktags`)
+	if err != nil {
+		return 0, err
+	}
+	type row struct {
+		bookID int
+		tag    string
+	}
+	var stale []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.bookID, &r.tag); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if tag, err := normalizeTag(slashFree(r.tag)); err != nil || tag != r.tag {
+			stale = append(stale, r)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, r := range stale {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM booktags WHERE bookID = ? AND tag = ?`, r.bookID, r.tag); err != nil {
+			return 0, err
+		}
+		if err := touchBooks(ctx, tx, `bookID = ?`, r.bookID); err != nil {
+			return 0, err
+		}
+		//tags that normalize to nothing (all whitespace) just get dropped
+		tag, err := normalizeTag(slashFree(r.tag))
+		if err != nil {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT IGNORE INTO booktags (bookID, tag) VALUES (?, ?)`, r.bookID, tag); err != nil {
+			return 0, err
+		}
+	}
+	return len(stale), tx.Commit()
+}
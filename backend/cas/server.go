@@ -10,6 +10,23 @@ import (
 
 //ignore all of this
 
+// DefaultURL is the CWRU CAS server that both the auth server and the API validate tickets against
+const DefaultURL = "https://login.case.edu/cas"
+
+// NewClient builds a CAS client pointed at casURL, falling back to DefaultURL when it's empty
+func NewClient(casURL string) (*cas_auth.Client, error) {
+	if casURL == "" {
+		casURL = DefaultURL
+	}
+	u, err := url.Parse(casURL)
+	if err != nil {
+		return nil, err
+	}
+	return cas_auth.NewClient(&cas_auth.Options{
+		URL: u,
+	}), nil
+}
+
 // RunCASServer starts the CAS authentication server
 func RunCASServer(port string) {
 	mux := http.NewServeMux()
@@ -48,10 +65,10 @@ func RunCASServer(port string) {
 	})
 
 	// Create CAS client middleware
-	casURL, _ := url.Parse("https://login.case.edu/cas")
-	client := cas_auth.NewClient(&cas_auth.Options{
-		URL: casURL,
-	})
+	client, err := NewClient(DefaultURL)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	addr := ":" + port
 	log.Printf("CAS auth server listening on %s", addr)
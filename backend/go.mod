@@ -2,7 +2,11 @@ module github.com/bxb454/csds-395-lgbt-library-catalog
 
 go 1.25.1
 
-require gopkg.in/cas.v2 v2.2.1
+require (
+	golang.org/x/image v0.32.0
+	golang.org/x/text v0.30.0
+	gopkg.in/cas.v2 v2.2.1
+)
 
 require filippo.io/edwards25519 v1.1.0 // indirect
 
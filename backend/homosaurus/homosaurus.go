@@ -0,0 +1,347 @@
+// Package homosaurus reads Homosaurus (https://homosaurus.org) vocabulary dumps into plain
+// terms the catalog can store. It understands the JSON-LD download and SKOS N-Triples,
+// and only keeps what the catalog uses: preferred/alternate labels and broader/narrower links.
+package homosaurus
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const skos = "http://www.w3.org/2004/02/skos/core#"
+
+// Term is one SKOS concept. Broader and Narrower hold concept URIs.
+type Term struct {
+	URI       string
+	PrefLabel string
+	AltLabels []string
+	Broader   []string
+	Narrower  []string
+}
+
+// ParseFile picks a parser from the file extension: .nt for N-Triples, anything else is treated as JSON-LD
+func ParseFile(path string) ([]Term, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".nt") {
+		return ParseNTriples(f)
+	}
+	return ParseJSONLD(f)
+}
+
+// collects statements about each concept before they're turned into Terms,
+// labels are keyed by language so we can prefer english
+type builder struct {
+	prefLabels map[string]map[string]string
+	altLabels  map[string][]label
+	broader    map[string][]string
+	narrower   map[string][]string
+	seen       map[string]bool
+}
+
+type label struct {
+	lang  string
+	value string
+}
+
+func newBuilder() *builder {
+	return &builder{
+		prefLabels: make(map[string]map[string]string),
+		altLabels:  make(map[string][]label),
+		broader:    make(map[string][]string),
+		narrower:   make(map[string][]string),
+		seen:       make(map[string]bool),
+	}
+}
+
+func (b *builder) add(subject, predicate string, object label) {
+	switch strings.TrimPrefix(predicate, skos) {
+	case "prefLabel":
+		if b.prefLabels[subject] == nil {
+			b.prefLabels[subject] = make(map[string]string)
+		}
+		b.prefLabels[subject][object.lang] = object.value
+	case "altLabel":
+		b.altLabels[subject] = append(b.altLabels[subject], object)
+	case "broader":
+		b.broader[subject] = append(b.broader[subject], object.value)
+	case "narrower":
+		b.narrower[subject] = append(b.narrower[subject], object.value)
+	default:
+		return
+	}
+	b.seen[subject] = true
+}
+
+// terms keeps only concepts that ended up with a preferred label, sorted by URI so imports are repeatable
+func (b *builder) terms() []Term {
+	var uris []string
+	for uri := range b.seen {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+
+	var result []Term
+	for _, uri := range uris {
+		pref := pickLabel(b.prefLabels[uri])
+		if pref == "" {
+			continue
+		}
+		t := Term{URI: uri, PrefLabel: pref}
+		for _, l := range b.altLabels[uri] {
+			if (l.lang == "" || strings.HasPrefix(l.lang, "en")) && l.value != pref {
+				t.AltLabels = appendUnique(t.AltLabels, l.value)
+			}
+		}
+		for _, u := range b.broader[uri] {
+			t.Broader = appendUnique(t.Broader, u)
+		}
+		for _, u := range b.narrower[uri] {
+			t.Narrower = appendUnique(t.Narrower, u)
+		}
+		result = append(result, t)
+	}
+	return result
+}
+
+// english first, then no language tag, then whatever there is
+func pickLabel(byLang map[string]string) string {
+	if v := byLang["en"]; v != "" {
+		return v
+	}
+	if v := byLang[""]; v != "" {
+		return v
+	}
+	var langs []string
+	for lang := range byLang {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	for _, lang := range langs {
+		if byLang[lang] != "" {
+			return byLang[lang]
+		}
+	}
+	return ""
+}
+
+func appendUnique(list []string, v string) []string {
+	for _, existing := range list {
+		if existing == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
+
+// ParseJSONLD reads the Homosaurus JSON-LD download. It handles both a top level array and
+// an object with "@graph", and predicates written as "skos:prefLabel", bare "prefLabel" or full IRIs.
+func ParseJSONLD(r io.Reader) ([]Term, error) {
+	var doc interface{}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("homosaurus: invalid json-ld: %w", err)
+	}
+
+	var nodes []interface{}
+	switch v := doc.(type) {
+	case []interface{}:
+		nodes = v
+	case map[string]interface{}:
+		if graph, ok := v["@graph"].([]interface{}); ok {
+			nodes = graph
+		} else {
+			nodes = []interface{}{v}
+		}
+	default:
+		return nil, errors.New("homosaurus: json-ld must be an object or array")
+	}
+
+	b := newBuilder()
+	for _, n := range nodes {
+		node, ok := n.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := node["@id"].(string)
+		if id == "" {
+			continue
+		}
+		for key, value := range node {
+			predicate := expandKey(key)
+			if !strings.HasPrefix(predicate, skos) {
+				continue
+			}
+			for _, object := range jsonLDValues(value) {
+				b.add(id, predicate, object)
+			}
+		}
+	}
+	return b.terms(), nil
+}
+
+func expandKey(key string) string {
+	switch {
+	case strings.HasPrefix(key, "skos:"):
+		return skos + strings.TrimPrefix(key, "skos:")
+	case key == "prefLabel" || key == "altLabel" || key == "broader" || key == "narrower":
+		return skos + key
+	}
+	return key
+}
+
+// jsonLDValues flattens a json-ld value into labels. {"@id"} nodes become a label holding the IRI.
+func jsonLDValues(v interface{}) []label {
+	switch val := v.(type) {
+	case string:
+		return []label{{value: val}}
+	case []interface{}:
+		var out []label
+		for _, item := range val {
+			out = append(out, jsonLDValues(item)...)
+		}
+		return out
+	case map[string]interface{}:
+		if id, ok := val["@id"].(string); ok {
+			return []label{{value: id}}
+		}
+		if s, ok := val["@value"].(string); ok {
+			lang, _ := val["@language"].(string)
+			return []label{{lang: strings.ToLower(lang), value: s}}
+		}
+	}
+	return nil
+}
+
+// ParseNTriples reads SKOS as N-Triples, one "<s> <p> <o> ." statement per line
+func ParseNTriples(r io.Reader) ([]Term, error) {
+	b := newBuilder()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		subject, rest, err := ntIRI(line)
+		if err != nil {
+			return nil, fmt.Errorf("homosaurus: line %d: %w", lineNo, err)
+		}
+		predicate, rest, err := ntIRI(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("homosaurus: line %d: %w", lineNo, err)
+		}
+		if !strings.HasPrefix(predicate, skos) {
+			continue
+		}
+		object, err := ntObject(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("homosaurus: line %d: %w", lineNo, err)
+		}
+		b.add(subject, predicate, object)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return b.terms(), nil
+}
+
+func ntIRI(s string) (string, string, error) {
+	if !strings.HasPrefix(s, "<") {
+		return "", "", fmt.Errorf("expected <iri>, got %q", truncate(s))
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 0 {
+		return "", "", errors.New("unterminated iri")
+	}
+	return s[1:end], s[end+1:], nil
+}
+
+func ntObject(s string) (label, error) {
+	if strings.HasPrefix(s, "<") {
+		iri, _, err := ntIRI(s)
+		return label{value: iri}, err
+	}
+	if !strings.HasPrefix(s, `"`) {
+		return label{}, fmt.Errorf("expected literal or iri, got %q", truncate(s))
+	}
+
+	var sb strings.Builder
+	i := 1
+	for ; i < len(s); i++ {
+		c := s[i]
+		if c == '"' {
+			break
+		}
+		if c == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			//the ECHAR escapes
+			case 't':
+				sb.WriteByte('\t')
+			case 'b':
+				sb.WriteByte('\b')
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 'f':
+				sb.WriteByte('\f')
+			case '"', '\'', '\\':
+				sb.WriteByte(s[i])
+			case 'u', 'U':
+				n := 4
+				if s[i] == 'U' {
+					n = 8
+				}
+				if i+n >= len(s) {
+					return label{}, errors.New("bad unicode escape")
+				}
+				r, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32)
+				if err != nil {
+					return label{}, errors.New("bad unicode escape")
+				}
+				sb.WriteRune(rune(r))
+				i += n
+			default:
+				return label{}, fmt.Errorf("bad escape \\%c", s[i])
+			}
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	if i >= len(s) {
+		return label{}, errors.New("unterminated literal")
+	}
+
+	l := label{value: sb.String()}
+	rest := s[i+1:]
+	if strings.HasPrefix(rest, "@") {
+		lang := rest[1:]
+		if end := strings.IndexAny(lang, " \t."); end >= 0 {
+			lang = lang[:end]
+		}
+		l.lang = strings.ToLower(lang)
+	}
+	return l, nil
+}
+
+func truncate(s string) string {
+	if len(s) > 40 {
+		return s[:40] + "..."
+	}
+	return s
+}
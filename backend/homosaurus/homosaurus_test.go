@@ -0,0 +1,173 @@
+package homosaurus
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	v3        = "https://homosaurus.org/v3/"
+	butches   = v3 + "homoit0000166"
+	lesbians  = v3 + "homoit0000842"
+	stoneFems = v3 + "homoit0001479"
+)
+
+// the same two concepts in every shape the parsers take, so they should all come out alike
+var wantTerms = []Term{
+	{URI: butches, PrefLabel: "Butches", AltLabels: []string{"Butch lesbians", "Bulldaggers"}, Broader: []string{lesbians}, Narrower: []string{stoneFems}},
+	{URI: lesbians, PrefLabel: "Lesbians", Narrower: []string{butches}},
+}
+
+func TestParseJSONLD(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"graph with skos prefixes", `{"@context": {}, "@graph": [
+			{"@id": "` + butches + `",
+			 "skos:prefLabel": [{"@value": "Butches", "@language": "en"}, {"@value": "Butchs", "@language": "fr"}],
+			 "skos:altLabel": [{"@value": "Butch lesbians", "@language": "EN"}, {"@value": "Bulldaggers"}, {"@value": "Gouines", "@language": "fr"}, "Butches"],
+			 "skos:broader": {"@id": "` + lesbians + `"},
+			 "skos:narrower": [{"@id": "` + stoneFems + `"}, {"@id": "` + stoneFems + `"}]},
+			{"@id": "` + lesbians + `", "skos:prefLabel": "Lesbians", "skos:narrower": {"@id": "` + butches + `"}}]}`},
+		{"top level array with bare keys", `[
+			{"@id": "` + butches + `", "prefLabel": {"@value": "Butches", "@language": "en-us"},
+			 "altLabel": ["Butch lesbians", "Bulldaggers"], "broader": "` + lesbians + `", "narrower": "` + stoneFems + `"},
+			{"@id": "` + lesbians + `", "prefLabel": "Lesbians", "narrower": "` + butches + `"}]`},
+		{"full iris, extra nodes ignored", `[
+			{"@id": "` + lesbians + `", "http://www.w3.org/2004/02/skos/core#prefLabel": "Lesbians",
+			 "http://www.w3.org/2004/02/skos/core#narrower": {"@id": "` + butches + `"}},
+			{"@id": "` + butches + `", "http://www.w3.org/2004/02/skos/core#prefLabel": "Butches",
+			 "http://www.w3.org/2004/02/skos/core#altLabel": ["Butch lesbians", "Bulldaggers"],
+			 "http://www.w3.org/2004/02/skos/core#broader": {"@id": "` + lesbians + `"},
+			 "http://www.w3.org/2004/02/skos/core#narrower": {"@id": "` + stoneFems + `"},
+			 "http://purl.org/dc/terms/identifier": "homoit0000166"},
+			{"@id": "` + stoneFems + `", "skos:broader": {"@id": "` + butches + `"}},
+			{"skos:prefLabel": "no id"},
+			"not a node"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			terms, err := ParseJSONLD(strings.NewReader(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(terms, wantTerms) {
+				t.Errorf("got %+v\nwant %+v", terms, wantTerms)
+			}
+		})
+	}
+}
+
+func TestParseJSONLDErrors(t *testing.T) {
+	for _, doc := range []string{``, `{"@graph": [`, `"just a string"`, `42`} {
+		if _, err := ParseJSONLD(strings.NewReader(doc)); err == nil {
+			t.Errorf("ParseJSONLD(%q) didn't fail", doc)
+		}
+	}
+}
+
+func TestParseNTriples(t *testing.T) {
+	const skosNT = "<http://www.w3.org/2004/02/skos/core#"
+	doc := `# homosaurus v3, two concepts
+<` + butches + `> ` + skosNT + `prefLabel> "Butches"@en .
+<` + butches + `> ` + skosNT + `prefLabel> "Butchs"@fr .
+<` + butches + `> ` + skosNT + `altLabel> "Butch lesbians"@en-US .
+<` + butches + `> ` + skosNT + `altLabel> "Bulldaggers" .
+<` + butches + `> ` + skosNT + `altLabel> "Gouines"@fr .
+<` + butches + `> ` + skosNT + `altLabel> "Butches"@en .
+<` + butches + `> ` + skosNT + `broader> <` + lesbians + `> .
+<` + butches + `> ` + skosNT + `narrower> <` + stoneFems + `> .
+<` + butches + `> <http://purl.org/dc/terms/identifier> "homoit0000166" .
+
+	<` + lesbians + `>	` + skosNT + `prefLabel>	"Lesbians" .
+<` + lesbians + `> ` + skosNT + `narrower> <` + butches + `> .
+<` + stoneFems + `> ` + skosNT + `broader> <` + butches + `> .
+`
+	terms, err := ParseNTriples(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(terms, wantTerms) {
+		t.Errorf("got %+v\nwant %+v", terms, wantTerms)
+	}
+}
+
+func TestParseNTriplesLiterals(t *testing.T) {
+	tests := []struct {
+		literal string
+		want    string
+	}{
+		{`"Two-spirit people"`, "Two-spirit people"},
+		{`"Queer \"theory\""`, `Queer "theory"`},
+		{`"Back\\slash"`, `Back\slash`},
+		{`"Line\nbreak\ttab"`, "Line\nbreak\ttab"},
+		{`"cr\r lf\n bs\b ff\f"`, "cr\r lf\n bs\b ff\f"},
+		{`"Kinder\'s \"Gay\""`, `Kinder's "Gay"`},
+		{`"Ma\u0304hu\u0304"`, "Ma\u0304hu\u0304"},
+		{`"Mähu"`, "Mähu"},
+		{`"Faʻafafine"`, "Faʻafafine"},
+		{`"\U0001F3F3️"`, "\U0001F3F3️"},
+		{`""`, ""},
+	}
+	for _, tt := range tests {
+		line := "<" + lesbians + "> <" + skos + "prefLabel> " + tt.literal + "@en .\n"
+		terms, err := ParseNTriples(strings.NewReader(line))
+		if err != nil {
+			t.Errorf("%s: %v", tt.literal, err)
+			continue
+		}
+		got := ""
+		if len(terms) == 1 {
+			got = terms[0].PrefLabel
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.literal, got, tt.want)
+		}
+	}
+}
+
+func TestParseNTriplesErrors(t *testing.T) {
+	prefLabel := "<" + skos + "prefLabel>"
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`"Lesbians" ` + prefLabel + ` "Lesbians" .`, "line 2: expected <iri>"},
+		{"<" + lesbians + " " + prefLabel + ` "Lesbians" .`, "line 2: expected <iri>"},
+		{"<" + lesbians, "line 2: unterminated iri"},
+		{"<" + lesbians + "> " + prefLabel + ` Lesbians .`, "line 2: expected literal or iri"},
+		{"<" + lesbians + "> " + prefLabel + ` "Lesbians .`, "line 2: unterminated literal"},
+		{"<" + lesbians + "> " + prefLabel + ` "Les\uZZZZians" .`, "line 2: bad unicode escape"},
+		{"<" + lesbians + "> " + prefLabel + ` "\u00E`, "line 2: bad unicode escape"},
+		{"<" + lesbians + "> " + prefLabel + ` "\u+0E9" .`, "line 2: bad unicode escape"},
+		{"<" + lesbians + "> " + prefLabel + ` "Lesbi\ans" .`, `line 2: bad escape \a`},
+	}
+	for _, tt := range tests {
+		doc := "# header\n" + tt.line + "\n"
+		_, err := ParseNTriples(strings.NewReader(doc))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.line, err, tt.want)
+		}
+	}
+}
+
+func TestPickLabel(t *testing.T) {
+	tests := []struct {
+		byLang map[string]string
+		want   string
+	}{
+		{map[string]string{"en": "Butches", "": "Butch", "fr": "Butchs"}, "Butches"},
+		{map[string]string{"": "Butch", "fr": "Butchs"}, "Butch"},
+		{map[string]string{"fr": "Butchs", "de": "Butches (de)"}, "Butches (de)"},
+		{map[string]string{"en": "", "es": "Butch (es)"}, "Butch (es)"},
+		{map[string]string{}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := pickLabel(tt.byLang); got != tt.want {
+			t.Errorf("pickLabel(%v) = %q, want %q", tt.byLang, got, tt.want)
+		}
+	}
+}
@@ -2,16 +2,18 @@ package main
 
 import (
 	//"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-
-	//"strings"
+	"strings"
 
 	//"./cas"
 	api "github.com/bxb454/csds-395-lgbt-library-catalog/api"
 	cas_test "github.com/bxb454/csds-395-lgbt-library-catalog/cas"
+	"github.com/bxb454/csds-395-lgbt-library-catalog/homosaurus"
+	"github.com/bxb454/csds-395-lgbt-library-catalog/marc"
 )
 
 func main() {
@@ -22,6 +24,11 @@ func main() {
 		fmt.Println("api-server     - Start the main API server (with DB)")
 		fmt.Println("test-cas       - Test CAS authentication")
 		fmt.Println("test-simple    - Test endpoints without auth")
+		fmt.Println("normalize-tags - Merge existing tags that only differ by case, spacing or unicode form")
+		fmt.Println("normalize-isbns - Rewrite stored ISBNs as ISBN-13, logging any that fail the checksum")
+		fmt.Println("import-books [-dry-run] <file.csv> - Add books, authors and tags from a spreadsheet export")
+		fmt.Println("import-marc [-dry-run] <file.mrc|file.xml> - Add books from binary MARC 21 or MARCXML records")
+		fmt.Println("import-homosaurus <file> - Load Homosaurus subject headings from a JSON-LD or N-Triples dump")
 		os.Exit(1)
 	}
 
@@ -37,6 +44,16 @@ func main() {
 		//cas_test.RunCASTest()
 	case "test-simple":
 		//runSimpleTest()
+	case "normalize-tags":
+		normalizeTags()
+	case "normalize-isbns":
+		normalizeISBNs()
+	case "import-books":
+		importBooks()
+	case "import-marc":
+		importMARC()
+	case "import-homosaurus":
+		importHomosaurus()
 	default:
 		log.Fatalf("Unknown command: %s", command)
 	}
@@ -66,6 +83,124 @@ func startAPIServer() {
 	}
 }
 
+func normalizeTags() {
+	srv, err := api.New()
+	if err != nil {
+		log.Fatalf("failed to connect: %v", err)
+	}
+
+	n, err := srv.NormalizeTags(context.Background())
+	if err != nil {
+		log.Fatalf("failed to normalize tags: %v", err)
+	}
+	fmt.Printf("Normalized %d tags\n", n)
+}
+
+func normalizeISBNs() {
+	srv, err := api.New()
+	if err != nil {
+		log.Fatalf("failed to connect: %v", err)
+	}
+
+	n, err := srv.NormalizeISBNs(context.Background())
+	if err != nil {
+		log.Fatalf("failed to normalize isbns: %v", err)
+	}
+	fmt.Printf("Normalized %d ISBNs\n", n)
+}
+
+func importBooks() {
+	var dryRun = flag.Bool("dry-run", false, "Validate and report without saving anything")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Println("Usage: go run main.go import-books [-dry-run] <file.csv>")
+		os.Exit(1)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("failed to open %s: %v", flag.Arg(0), err)
+	}
+	defer f.Close()
+
+	srv, err := api.New()
+	if err != nil {
+		log.Fatalf("failed to connect: %v", err)
+	}
+	report, err := srv.ImportBooks(context.Background(), f, *dryRun)
+	if err != nil {
+		log.Fatalf("import failed: %v", err)
+	}
+	printImportReport(report)
+}
+
+func importMARC() {
+	var dryRun = flag.Bool("dry-run", false, "Validate and report without saving anything")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Println("Usage: go run main.go import-marc [-dry-run] <file.mrc|file.xml>")
+		os.Exit(1)
+	}
+
+	records, err := marc.ParseFile(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("failed to read records: %v", err)
+	}
+
+	srv, err := api.New()
+	if err != nil {
+		log.Fatalf("failed to connect: %v", err)
+	}
+	report, err := srv.ImportMARC(context.Background(), records, *dryRun)
+	if err != nil {
+		log.Fatalf("import failed: %v", err)
+	}
+	printImportReport(report)
+}
+
+func printImportReport(report api.ImportReport) {
+	for _, row := range report.Rows {
+		switch row.Status {
+		case "error":
+			fmt.Printf("row %d (%s): %s\n", row.Row, row.Title, strings.Join(row.Errors, "; "))
+		case "duplicate":
+			fmt.Printf("row %d (%s): duplicate of %s, skipped\n", row.Row, row.Title, row.DuplicateOf)
+		}
+	}
+	switch {
+	case report.Errors > 0:
+		fmt.Printf("%d rows have errors, nothing was imported\n", report.Errors)
+		os.Exit(1)
+	case report.DryRun:
+		fmt.Printf("Dry run: would import %d books and create %d authors (%d duplicates skipped)\n", report.Books, report.NewAuthors, report.Duplicates)
+	default:
+		fmt.Printf("Imported %d books and created %d authors (%d duplicates skipped)\n", report.Books, report.NewAuthors, report.Duplicates)
+	}
+}
+
+func importHomosaurus() {
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Println("Usage: go run main.go import-homosaurus <file.jsonld|file.nt>")
+		os.Exit(1)
+	}
+
+	terms, err := homosaurus.ParseFile(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("failed to read vocabulary: %v", err)
+	}
+
+	srv, err := api.New()
+	if err != nil {
+		log.Fatalf("failed to connect: %v", err)
+	}
+	n, err := srv.ImportSubjects(context.Background(), terms)
+	if err != nil {
+		log.Fatalf("import failed: %v", err)
+	}
+	fmt.Printf("Imported %d subject headings\n", n)
+}
+
 /*
 func runSimpleTest() {
 	if len(os.Args) < 2 {
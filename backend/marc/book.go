@@ -0,0 +1,223 @@
+package marc
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HomosaurusSource is the MARC source code ($2) for Homosaurus headings
+const HomosaurusSource = "homoit"
+
+// Book is the part of a bibliographic record the catalog keeps
+type Book struct {
+	ID        int      //001 on export, ignored on import
+	ISBNs     []string //020 $a, qualifiers like "(pbk.)" stripped
+	Title     string   //245 $a $b
+	Edition   string   //250 $a
+	Publisher string   //264 $b, or 260 $b on older records
+	Year      string   //264/260 $c, or 008/07-10
+	Authors   []Name   //100 then 700
+	Subjects  []Subject
+	Tags      []string //653, uncontrolled index terms
+}
+
+// Name is a personal name split at the first comma of the inverted form, "Feinberg, Leslie"
+type Name struct {
+	LName string
+	FName string
+}
+
+// Subject is a 650 topical heading. URI comes from $0 or $1 when it's a link
+type Subject struct {
+	URI    string
+	Label  string
+	Source string //$2, or lcsh for second indicator 0
+}
+
+var (
+	yearPattern = regexp.MustCompile(`\d{4}`)
+	isbnPattern = regexp.MustCompile(`^[0-9Xx-]+`)
+)
+
+// ToBook pulls the catalog's fields out of a record
+func ToBook(r Record) Book {
+	var b Book
+	for _, f := range r.Get("020") {
+		if isbn := isbnPattern.FindString(strings.TrimSpace(f.First("a"))); isbn != "" {
+			b.ISBNs = append(b.ISBNs, isbn)
+		}
+	}
+	for _, f := range r.Get("245") {
+		b.Title = trimPeriod(f.First("a"))
+		if sub := trimPeriod(f.First("b")); sub != "" {
+			b.Title += ": " + sub
+		}
+		break
+	}
+	for _, f := range r.Get("250") {
+		b.Edition = trimPunct(f.First("a"))
+		break
+	}
+
+	//264 with second indicator 1 is the publication statement, 260 is what records used before RDA
+	var pub *Field
+	for _, f := range r.Get("264") {
+		if f.Ind2 == "1" {
+			pub = &f
+			break
+		}
+	}
+	if pub == nil {
+		if fields := r.Get("260"); len(fields) > 0 {
+			pub = &fields[0]
+		}
+	}
+	if pub != nil {
+		b.Publisher = trimPunct(pub.First("b"))
+		b.Year = yearPattern.FindString(pub.First("c"))
+	}
+	if fixed := r.Control("008"); b.Year == "" && len(fixed) >= 11 {
+		if _, err := strconv.Atoi(fixed[7:11]); err == nil {
+			b.Year = fixed[7:11]
+		}
+	}
+
+	for _, tag := range []string{"100", "700"} {
+		for _, f := range r.Get(tag) {
+			//a 700 with a title is a related work, not a contributor
+			if f.First("t") != "" {
+				continue
+			}
+			if n := splitName(f.First("a")); n.LName != "" {
+				b.Authors = append(b.Authors, n)
+			}
+		}
+	}
+
+	for _, f := range r.Get("650") {
+		parts := []string{trimPeriod(f.First("a"))}
+		for _, s := range f.Subfields {
+			if s.Code == "x" || s.Code == "y" || s.Code == "z" || s.Code == "v" {
+				parts = append(parts, trimPeriod(s.Value))
+			}
+		}
+		sub := Subject{Label: strings.Join(parts, " -- "), Source: f.First("2")}
+		if sub.Source == "" && f.Ind2 == "0" {
+			sub.Source = "lcsh"
+		}
+		for _, uri := range append(f.Sub("1"), f.Sub("0")...) {
+			if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
+				sub.URI = uri
+				break
+			}
+		}
+		if parts[0] != "" || sub.URI != "" {
+			b.Subjects = append(b.Subjects, sub)
+		}
+	}
+	for _, f := range r.Get("653") {
+		for _, term := range f.Sub("a") {
+			if term = trimPeriod(term); term != "" {
+				b.Tags = append(b.Tags, term)
+			}
+		}
+	}
+	return b
+}
+
+// trimPunct drops the ISBD punctuation MARC leaves at the end of subfields ("Stone butch blues :")
+func trimPunct(s string) string {
+	return strings.TrimRight(strings.TrimSpace(s), " /:;,=")
+}
+
+// trimPeriod also drops the full stop that ends a field, for values that aren't abbreviations
+func trimPeriod(s string) string {
+	return trimPunct(strings.TrimSuffix(trimPunct(s), "."))
+}
+
+func splitName(s string) Name {
+	s = trimPeriod(s)
+	last, first, _ := strings.Cut(s, ",")
+	return Name{LName: strings.TrimSpace(last), FName: strings.TrimSpace(first)}
+}
+
+// FromBook builds a minimal-level record (leader/17 = 7) for a book. Headings with a URI
+// are written as 650 _7 with their source in $2, tags go in 653
+func FromBook(b Book) Record {
+	r := Record{Leader: "00000nam a22000007  4500"}
+	r.Fields = append(r.Fields, Field{Tag: "001", Value: strconv.Itoa(b.ID)})
+
+	//008: date entered, single date or unknown, then "no attempt to code" for the book specific positions
+	dateType, year := "s", b.Year
+	if len(year) != 4 {
+		dateType, year = "n", "uuuu"
+	}
+	fixed := time.Now().Format("060102") + dateType + year + "    " + "xx " + strings.Repeat("|", 17) + "und" + " d"
+	r.Fields = append(r.Fields, Field{Tag: "008", Value: fixed})
+
+	for _, isbn := range b.ISBNs {
+		r.Fields = append(r.Fields, dataField("020", " ", " ", "a", isbn))
+	}
+	for i, a := range b.Authors {
+		tag := "700"
+		if i == 0 {
+			tag = "100"
+		}
+		name, ind1 := a.LName, "0"
+		if a.FName != "" {
+			name, ind1 = a.LName+", "+a.FName, "1"
+		}
+		r.Fields = append(r.Fields, dataField(tag, ind1, " ", "a", name))
+	}
+
+	ind1 := "0"
+	if len(b.Authors) > 0 {
+		ind1 = "1"
+	}
+	r.Fields = append(r.Fields, dataField("245", ind1, strconv.Itoa(nonfiling(b.Title)), "a", b.Title))
+	if b.Edition != "" {
+		r.Fields = append(r.Fields, dataField("250", " ", " ", "a", b.Edition))
+	}
+	if b.Publisher != "" || b.Year != "" {
+		f := Field{Tag: "264", Ind1: " ", Ind2: "1"}
+		if b.Publisher != "" {
+			f.Subfields = append(f.Subfields, Subfield{Code: "b", Value: b.Publisher})
+		}
+		if len(b.Year) == 4 {
+			f.Subfields = append(f.Subfields, Subfield{Code: "c", Value: b.Year})
+		}
+		r.Fields = append(r.Fields, f)
+	}
+
+	for _, s := range b.Subjects {
+		f := dataField("650", " ", "7", "a", s.Label)
+		if s.URI != "" {
+			f.Subfields = append(f.Subfields, Subfield{Code: "0", Value: s.URI})
+		}
+		if s.Source != "" {
+			f.Subfields = append(f.Subfields, Subfield{Code: "2", Value: s.Source})
+		}
+		r.Fields = append(r.Fields, f)
+	}
+	for _, tag := range b.Tags {
+		r.Fields = append(r.Fields, dataField("653", " ", " ", "a", tag))
+	}
+	return r
+}
+
+func dataField(tag, ind1, ind2, code, value string) Field {
+	return Field{Tag: tag, Ind1: ind1, Ind2: ind2, Subfields: []Subfield{{Code: code, Value: value}}}
+}
+
+// nonfiling is the 245 second indicator, how many leading characters to skip when sorting
+func nonfiling(title string) int {
+	lower := strings.ToLower(title)
+	for _, article := range []string{"the ", "an ", "a "} {
+		if strings.HasPrefix(lower, article) {
+			return len(article)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,348 @@
+// Package marc reads and writes MARC 21 bibliographic records. It reads binary MARC 21
+// (ISO 2709) and MARCXML, writes MARCXML, and maps records to and from the handful of
+// fields the catalog keeps (see Book). Binary records are expected in UTF-8 (leader/09 = a),
+// MARC-8 records only come through intact if they're plain ASCII.
+package marc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	recordTerminator = 0x1D
+	fieldTerminator  = 0x1E
+	subfieldDelim    = 0x1F
+
+	leaderLength = 24
+	dirEntryLen  = 12
+
+	// Namespace is the MARCXML (slim) schema namespace
+	Namespace = "http://www.loc.gov/MARC21/slim"
+)
+
+// Record is one MARC record. Fields keep the order they were read in.
+type Record struct {
+	Leader string
+	Fields []Field
+}
+
+// Field is a control field (tags 001-009, only Value is set) or a data field with indicators and subfields
+type Field struct {
+	Tag       string
+	Ind1      string
+	Ind2      string
+	Value     string
+	Subfields []Subfield
+}
+
+type Subfield struct {
+	Code  string
+	Value string
+}
+
+// IsControl reports whether the tag is a control field (00X)
+func IsControl(tag string) bool {
+	return strings.HasPrefix(tag, "00")
+}
+
+// Get returns every field with the given tag
+func (r Record) Get(tag string) []Field {
+	var result []Field
+	for _, f := range r.Fields {
+		if f.Tag == tag {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// Control returns the value of the first control field with the given tag
+func (r Record) Control(tag string) string {
+	for _, f := range r.Fields {
+		if f.Tag == tag {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Sub returns the values of every subfield with the given code, in order
+func (f Field) Sub(code string) []string {
+	var result []string
+	for _, s := range f.Subfields {
+		if s.Code == code {
+			result = append(result, s.Value)
+		}
+	}
+	return result
+}
+
+// First returns the first subfield with the given code, or ""
+func (f Field) First(code string) string {
+	for _, s := range f.Subfields {
+		if s.Code == code {
+			return s.Value
+		}
+	}
+	return ""
+}
+
+// ParseFile reads a MARC file, MARCXML if it starts with '<' (after any whitespace or byte order mark), binary otherwise
+func ParseFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse sniffs the format like ParseFile does
+func Parse(r io.Reader) ([]Record, error) {
+	br := bufio.NewReader(r)
+	for {
+		c, _, err := br.ReadRune()
+		if err == io.EOF {
+			return nil, errors.New("marc: empty file")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c == '\ufeff' || c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			continue
+		}
+		br.UnreadRune()
+		if c == '<' {
+			return ReadXML(br)
+		}
+		return ReadBinary(br)
+	}
+}
+
+// ReadBinary reads ISO 2709 records until EOF
+func ReadBinary(r io.Reader) ([]Record, error) {
+	br := bufio.NewReader(r)
+	var records []Record
+	for n := 1; ; n++ {
+		head := make([]byte, 5)
+		if _, err := io.ReadFull(br, head); err != nil {
+			if err == io.EOF {
+				return records, nil
+			}
+			return nil, fmt.Errorf("marc: record %d: %w", n, err)
+		}
+		//some exports put a newline between records
+		if trimmed := bytes.TrimLeft(head, "\r\n"); len(trimmed) < len(head) {
+			rest := make([]byte, len(head)-len(trimmed))
+			if _, err := io.ReadFull(br, rest); err != nil {
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					return records, nil
+				}
+				return nil, err
+			}
+			head = append(trimmed, rest...)
+		}
+		length, ok := digits(head)
+		if !ok || length < leaderLength+1 {
+			return nil, fmt.Errorf("marc: record %d: bad record length %q", n, head)
+		}
+		data := make([]byte, length)
+		copy(data, head)
+		if _, err := io.ReadFull(br, data[5:]); err != nil {
+			return nil, fmt.Errorf("marc: record %d: truncated", n)
+		}
+		rec, err := decodeBinary(data)
+		if err != nil {
+			return nil, fmt.Errorf("marc: record %d: %w", n, err)
+		}
+		records = append(records, rec)
+	}
+}
+
+// digits reads a fixed width number from the leader or directory. unlike strconv.Atoi it takes
+// nothing but ascii digits, a sign or a space is a broken record
+func digits(b []byte) (int, bool) {
+	if len(b) == 0 {
+		return 0, false
+	}
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
+
+// decodeBinary reads one whole record, leader to record terminator. every offset in it comes from
+// an uploaded file, so each one is checked before anything is sliced
+func decodeBinary(data []byte) (Record, error) {
+	if len(data) < leaderLength+1 {
+		return Record{}, errors.New("record is shorter than its leader")
+	}
+	if data[len(data)-1] != recordTerminator {
+		return Record{}, errors.New("missing record terminator")
+	}
+	rec := Record{Leader: string(data[:leaderLength])}
+	//the directory ends with a field terminator right before base
+	base, ok := digits(data[12:17])
+	if !ok || base <= leaderLength || base > len(data) {
+		return Record{}, fmt.Errorf("bad base address %q", data[12:17])
+	}
+
+	dir := data[leaderLength : base-1]
+	if len(dir)%dirEntryLen != 0 {
+		return Record{}, errors.New("directory length isn't a multiple of 12")
+	}
+	for i := 0; i < len(dir); i += dirEntryLen {
+		entry := dir[i : i+dirEntryLen]
+		tag := string(entry[:3])
+		length, ok1 := digits(entry[3:7])
+		start, ok2 := digits(entry[7:12])
+		if !ok1 || !ok2 || base+start+length > len(data)-1 {
+			return Record{}, fmt.Errorf("bad directory entry for %s", tag)
+		}
+		raw := bytes.TrimSuffix(data[base+start:base+start+length], []byte{fieldTerminator})
+
+		if IsControl(tag) {
+			rec.Fields = append(rec.Fields, Field{Tag: tag, Value: text(raw)})
+			continue
+		}
+		f := Field{Tag: tag, Ind1: " ", Ind2: " "}
+		if len(raw) >= 2 {
+			f.Ind1, f.Ind2 = string(raw[0]), string(raw[1])
+			raw = raw[2:]
+		}
+		for _, part := range bytes.Split(raw, []byte{subfieldDelim}) {
+			if len(part) == 0 {
+				continue
+			}
+			_, size := utf8.DecodeRune(part)
+			f.Subfields = append(f.Subfields, Subfield{Code: string(part[:size]), Value: text(part[size:])})
+		}
+		rec.Fields = append(rec.Fields, f)
+	}
+	return rec, nil
+}
+
+// MARC 21 UTF-8 is usually decomposed, the rest of the catalog stores composed text
+func text(b []byte) string {
+	return norm.NFC.String(strings.ToValidUTF8(string(b), "\ufffd"))
+}
+
+type xmlCollection struct {
+	XMLName xml.Name    `xml:"collection"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Records []xmlRecord `xml:"record"`
+}
+
+type xmlRecord struct {
+	Leader  string         `xml:"leader"`
+	Control []xmlControl   `xml:"controlfield"`
+	Data    []xmlDataField `xml:"datafield"`
+}
+
+type xmlControl struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlDataField struct {
+	Tag       string        `xml:"tag,attr"`
+	Ind1      string        `xml:"ind1,attr"`
+	Ind2      string        `xml:"ind2,attr"`
+	Subfields []xmlSubfield `xml:"subfield"`
+}
+
+type xmlSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+// ReadXML reads every <record> in a MARCXML document, whether it's wrapped in a <collection> or not
+func ReadXML(r io.Reader) ([]Record, error) {
+	dec := xml.NewDecoder(r)
+	var records []Record
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("marc: invalid marcxml: %w", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "record" {
+			continue
+		}
+		var xr xmlRecord
+		if err := dec.DecodeElement(&xr, &start); err != nil {
+			return nil, fmt.Errorf("marc: record %d: %w", len(records)+1, err)
+		}
+		rec := Record{Leader: xr.Leader}
+		for _, c := range xr.Control {
+			rec.Fields = append(rec.Fields, Field{Tag: c.Tag, Value: norm.NFC.String(c.Value)})
+		}
+		for _, d := range xr.Data {
+			f := Field{Tag: d.Tag, Ind1: indicator(d.Ind1), Ind2: indicator(d.Ind2)}
+			for _, s := range d.Subfields {
+				f.Subfields = append(f.Subfields, Subfield{Code: s.Code, Value: norm.NFC.String(s.Value)})
+			}
+			rec.Fields = append(rec.Fields, f)
+		}
+		records = append(records, rec)
+	}
+}
+
+func indicator(s string) string {
+	if s == "" {
+		return " "
+	}
+	return s
+}
+
+// WriteXML writes the records as a MARCXML <collection>, fields sorted by tag
+func WriteXML(w io.Writer, records []Record) error {
+	coll := xmlCollection{Xmlns: Namespace}
+	for _, rec := range records {
+		fields := append([]Field(nil), rec.Fields...)
+		sort.SliceStable(fields, func(i, j int) bool { return fields[i].Tag < fields[j].Tag })
+
+		xr := xmlRecord{Leader: rec.Leader}
+		for _, f := range fields {
+			if IsControl(f.Tag) {
+				xr.Control = append(xr.Control, xmlControl{Tag: f.Tag, Value: f.Value})
+				continue
+			}
+			d := xmlDataField{Tag: f.Tag, Ind1: indicator(f.Ind1), Ind2: indicator(f.Ind2)}
+			for _, s := range f.Subfields {
+				d.Subfields = append(d.Subfields, xmlSubfield{Code: s.Code, Value: s.Value})
+			}
+			xr.Data = append(xr.Data, d)
+		}
+		coll.Records = append(coll.Records, xr)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(coll); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
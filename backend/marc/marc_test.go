@@ -0,0 +1,204 @@
+package marc
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// encodeBinary writes rec as ISO 2709, the package only reads binary so the tests make their own
+func encodeBinary(rec Record) []byte {
+	var dir, body bytes.Buffer
+	for _, f := range rec.Fields {
+		var field bytes.Buffer
+		if IsControl(f.Tag) {
+			field.WriteString(f.Value)
+		} else {
+			field.WriteString(f.Ind1 + f.Ind2)
+			for _, s := range f.Subfields {
+				field.WriteByte(subfieldDelim)
+				field.WriteString(s.Code + s.Value)
+			}
+		}
+		field.WriteByte(fieldTerminator)
+		fmt.Fprintf(&dir, "%s%04d%05d", f.Tag, field.Len(), body.Len())
+		body.Write(field.Bytes())
+	}
+	dir.WriteByte(fieldTerminator)
+	base := leaderLength + dir.Len()
+	length := base + body.Len() + 1
+	leader := []byte(rec.Leader)
+	copy(leader[0:5], fmt.Sprintf("%05d", length))
+	copy(leader[12:17], fmt.Sprintf("%05d", base))
+
+	var out bytes.Buffer
+	out.Write(leader)
+	out.Write(dir.Bytes())
+	out.Write(body.Bytes())
+	out.WriteByte(recordTerminator)
+	return out.Bytes()
+}
+
+var stoneButch = Book{
+	ISBNs:     []string{"9781555838539"},
+	Title:     "Stone butch blues",
+	Edition:   "20th anniversary edition",
+	Publisher: "Alyson",
+	Year:      "1993",
+	Authors:   []Name{{LName: "Feinberg", FName: "Leslie"}, {LName: "Lorde", FName: "Audre"}},
+	Subjects:  []Subject{{URI: "https://homosaurus.org/v3/homoit0001479", Label: "Butches", Source: HomosaurusSource}},
+	Tags:      []string{"fiction", "trans"},
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	data := append(encodeBinary(FromBook(stoneButch)), encodeBinary(FromBook(stoneButch))...)
+	records, err := ReadBinary(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("read %d records, want 2", len(records))
+	}
+	if got := ToBook(records[0]); !reflect.DeepEqual(got, stoneButch) {
+		t.Errorf("round trip:\n got %+v\nwant %+v", got, stoneButch)
+	}
+	//the author order decides 100 vs 700
+	if got := records[0].Get("100")[0].First("a"); got != "Feinberg, Leslie" {
+		t.Errorf("100 $a = %q, want the first author", got)
+	}
+}
+
+func TestXMLRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteXML(&buf, []Record{FromBook(stoneButch)}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := ReadXML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("read %d records, want 1", len(records))
+	}
+	if got := ToBook(records[0]); !reflect.DeepEqual(got, stoneButch) {
+		t.Errorf("round trip:\n got %+v\nwant %+v", got, stoneButch)
+	}
+}
+
+func TestParseSniffsFormat(t *testing.T) {
+	var xmlBuf bytes.Buffer
+	if err := WriteXML(&xmlBuf, []Record{FromBook(stoneButch)}); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"binary": encodeBinary(FromBook(stoneButch)),
+		"xml":    xmlBuf.Bytes(),
+	} {
+		records, err := Parse(bytes.NewReader(data))
+		if err != nil || len(records) != 1 {
+			t.Errorf("%s: got %d records, %v", name, len(records), err)
+		}
+	}
+}
+
+// valid is a small good record the malformed cases are cut out of
+func valid() []byte {
+	return encodeBinary(Record{
+		Leader: "00000nam a22000007a 4500",
+		Fields: []Field{{Tag: "001", Value: "1"}, dataField("245", "1", "0", "a", "Zami")},
+	})
+}
+
+// with returns a copy of data with b written at i
+func with(data []byte, i int, b string) []byte {
+	out := append([]byte(nil), data...)
+	copy(out[i:], b)
+	return out
+}
+
+func TestDecodeBinaryMalformed(t *testing.T) {
+	good := valid()
+	if _, err := decodeBinary(good); err != nil {
+		t.Fatalf("the valid record doesn't decode: %v", err)
+	}
+	//the directory starts right after the leader, entries are tag(3) length(4) start(5)
+	const entry = leaderLength
+	tests := map[string][]byte{
+		"empty":                 {},
+		"just a terminator":     {recordTerminator},
+		"shorter than a leader": []byte("00010nam" + string(rune(recordTerminator))),
+		"no record terminator":  good[:len(good)-1],
+		"signed base":           with(good, 12, "-0030"),
+		"plus signed base":      with(good, 12, "+0049"),
+		"base past the end":     with(good, 12, "99999"),
+		"base inside leader":    with(good, 12, "00010"),
+		"spaces in base":        with(good, 12, "   49"),
+		"negative length":       with(good, entry+3, "-001"),
+		"negative start":        with(good, entry+7, "-0001"),
+		"signed start":          with(good, entry+7, "+0001"),
+		"field past the end":    with(good, entry+3, "9999"),
+		"start past the end":    with(good, entry+7, "99999"),
+		"directory not 12s":     with(good, 12, fmt.Sprintf("%05d", len(good)-2)),
+	}
+	for name, data := range tests {
+		if _, err := decodeBinary(data); err == nil {
+			t.Errorf("%s: decoded without an error", name)
+		}
+	}
+}
+
+func TestReadBinaryTruncated(t *testing.T) {
+	good := valid()
+	tests := map[string][]byte{
+		"cut in the leader": good[:10],
+		"cut in the body":   good[:len(good)-5],
+		"bad length":        with(good, 0, "abcde"),
+		"negative length":   with(good, 0, "-0050"),
+		"length too short":  with(good, 0, "00010"),
+		"second record cut": append(append([]byte(nil), good...), good[:30]...),
+	}
+	for name, data := range tests {
+		if _, err := ReadBinary(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: read without an error", name)
+		}
+	}
+}
+
+func FuzzDecodeBinary(f *testing.F) {
+	f.Add(valid())
+	f.Add(encodeBinary(FromBook(stoneButch)))
+	f.Add(with(valid(), leaderLength+3, "-001"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		//anything goes as long as it doesn't panic
+		decodeBinary(data)
+		ReadBinary(bytes.NewReader(data))
+	})
+}
+
+func TestToBookFallbacks(t *testing.T) {
+	rec := Record{Leader: "00000nam a2200000 a 4500", Fields: []Field{
+		{Tag: "008", Value: "850101s1984    xx            000 0 eng d"},
+		dataField("020", " ", " ", "a", "0-89594-141-6 (pbk.)"),
+		dataField("100", "1", " ", "a", "Lorde, Audre."),
+		{Tag: "245", Ind1: "1", Ind2: "0", Subfields: []Subfield{{Code: "a", Value: "Sister outsider :"}, {Code: "b", Value: "essays and speeches /"}}},
+		dataField("260", " ", " ", "b", "Crossing Press,"),
+	}}
+	b := ToBook(rec)
+	if b.Year != "1984" {
+		t.Errorf("year from 008 = %q, want 1984", b.Year)
+	}
+	if !reflect.DeepEqual(b.ISBNs, []string{"0-89594-141-6"}) {
+		t.Errorf("isbns = %q", b.ISBNs)
+	}
+	if !strings.HasPrefix(b.Title, "Sister outsider") || !strings.Contains(b.Title, "essays and speeches") {
+		t.Errorf("title = %q", b.Title)
+	}
+	if b.Publisher != "Crossing Press" {
+		t.Errorf("publisher from 260 = %q", b.Publisher)
+	}
+	if len(b.Authors) != 1 || b.Authors[0] != (Name{LName: "Lorde", FName: "Audre"}) {
+		t.Errorf("authors = %+v", b.Authors)
+	}
+}
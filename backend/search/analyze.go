@@ -0,0 +1,54 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// words too common to say anything about a book. they're left out of the index and out of queries,
+// so "the stone butch blues" still finds "Stone Butch Blues"
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "in": true, "into": true, "is": true, "it": true, "of": true, "on": true,
+	"or": true, "the": true, "to": true, "with": true,
+}
+
+// Fold lowercases and strips accents and apostrophes, "Zami’s" and "zamis" fold the same
+func Fold(s string) string {
+	var sb strings.Builder
+	for _, r := range norm.NFKD.String(s) {
+		if unicode.Is(unicode.Mn, r) || r == '\'' || r == '’' {
+			continue
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return sb.String()
+}
+
+// Tokenize splits folded text into words, anything that isn't a letter or digit separates them
+func Tokenize(s string) []string {
+	return strings.FieldsFunc(Fold(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Term is what a word is indexed and looked up under: stemmed, or "" for a stopword
+func Term(word string) string {
+	if stopwords[word] {
+		return ""
+	}
+	return Stem(word)
+}
+
+// Analyze is Tokenize then Term, stopwords dropped
+func Analyze(s string) []string {
+	var terms []string
+	for _, w := range Tokenize(s) {
+		if t := Term(w); t != "" {
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
@@ -0,0 +1,157 @@
+package search
+
+import (
+	"sort"
+	"strings"
+)
+
+// fields that autocomplete offers, and what a Completion calls them. never AuthorAlias, the
+// search box would be showing people's former names to anyone typing
+var completeKinds = map[Field]string{Title: "title", Author: "author", Tag: "tag"}
+
+// Completion is one thing the search box can offer while someone types
+type Completion struct {
+	Kind  string `json:"kind"` //title, author or tag
+	Text  string `json:"text"`
+	Books int    `json:"books"` //how many books have it
+}
+
+// phrase is a title, author name or tag, counted over the books that have it
+type phrase struct {
+	Completion
+	keyed bool //its keys are in ix.keys
+}
+
+// completionKey is a phrase from one of its words on, folded. "Audre Lorde" has
+// "audre lorde" and "lorde", so typing either a first or last name finds it
+type completionKey struct {
+	key   string
+	start bool //key is the whole phrase
+	p     *phrase
+}
+
+func phraseID(kind, folded string) string {
+	return kind + "\x00" + folded
+}
+
+// addPhrases counts the doc's titles, author names and tags. new phrases get their keys appended,
+// sortKeys puts them in order once the write is done
+func (ix *Index) addPhrases(e *entry) {
+	for f, values := range e.doc.values() {
+		kind, ok := completeKinds[Field(f)]
+		if !ok {
+			continue
+		}
+		for _, v := range values {
+			words := Tokenize(v)
+			if len(words) == 0 {
+				continue
+			}
+			id := phraseID(kind, strings.Join(words, " "))
+			if contains(e.phrases, id) {
+				continue
+			}
+			e.phrases = append(e.phrases, id)
+			p := ix.phrases[id]
+			if p == nil {
+				p = &phrase{Completion: Completion{Kind: kind, Text: strings.TrimSpace(v)}}
+				ix.phrases[id] = p
+			}
+			p.Books++
+			if !p.keyed {
+				p.keyed = true
+				for i := range words {
+					ix.keys = append(ix.keys, completionKey{key: strings.Join(words[i:], " "), start: i == 0, p: p})
+				}
+				ix.keysDirty = true
+			}
+		}
+	}
+}
+
+// removePhrases uncounts the doc's phrases. ones no book has anymore are dropped in sortKeys,
+// a Put puts most of them right back
+func (ix *Index) removePhrases(e *entry) {
+	for _, id := range e.phrases {
+		if p := ix.phrases[id]; p != nil {
+			p.Books--
+			if p.Books == 0 {
+				ix.keysDirty = true
+			}
+		}
+	}
+}
+
+// sortKeys drops the keys of phrases no book has and sorts the rest, after every write
+func (ix *Index) sortKeys() {
+	if !ix.keysDirty {
+		return
+	}
+	keys := ix.keys[:0]
+	for _, k := range ix.keys {
+		if k.p.Books > 0 {
+			keys = append(keys, k)
+		}
+	}
+	clear(ix.keys[len(keys):])
+	ix.keys = keys
+	for id, p := range ix.phrases {
+		if p.Books == 0 {
+			delete(ix.phrases, id)
+		}
+	}
+	sort.Slice(ix.keys, func(i, j int) bool { return ix.keys[i].key < ix.keys[j].key })
+	ix.keysDirty = false
+}
+
+// Complete returns up to limit titles, author names and tags with a word starting with prefix, or
+// a run of words when prefix has several ("audre lo"). ones that start with it come first, then
+// the ones on the most books
+func (ix *Index) Complete(prefix string, limit int) []Completion {
+	q := strings.Join(Tokenize(prefix), " ")
+	if q == "" || limit <= 0 {
+		return nil
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	best := make(map[*phrase]bool) //phrase -> matched at its start
+	for i := sort.Search(len(ix.keys), func(i int) bool { return ix.keys[i].key >= q }); i < len(ix.keys); i++ {
+		k := ix.keys[i]
+		if !strings.HasPrefix(k.key, q) {
+			break
+		}
+		best[k.p] = best[k.p] || k.start
+	}
+	matches := make([]*phrase, 0, len(best))
+	for p := range best {
+		matches = append(matches, p)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if best[a] != best[b] {
+			return best[a]
+		}
+		if a.Books != b.Books {
+			return a.Books > b.Books
+		}
+		if a.Text != b.Text {
+			return a.Text < b.Text
+		}
+		return a.Kind < b.Kind
+	})
+	result := make([]Completion, 0, min(limit, len(matches)))
+	for _, p := range matches[:min(limit, len(matches))] {
+		result = append(result, p.Completion)
+	}
+	return result
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
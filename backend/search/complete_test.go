@@ -0,0 +1,57 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	ix := testIndex()
+	tests := []struct {
+		prefix string
+		limit  int
+		want   []Completion
+	}{
+		{"audre lo", 8, []Completion{{Kind: "author", Text: "Audre Lorde", Books: 2}}},
+		//a later word matches too, behind phrases that start with it
+		{"lor", 8, []Completion{{Kind: "author", Text: "Audre Lorde", Books: 2}}},
+		{"les", 8, []Completion{{Kind: "tag", Text: "lesbian", Books: 2}, {Kind: "author", Text: "Leslie Feinberg", Books: 1}}},
+		{"ZAMI", 8, []Completion{{Kind: "title", Text: "Zami: A New Spelling of My Name", Books: 1}}},
+		{"fiction", 8, []Completion{{Kind: "tag", Text: "fiction", Books: 2}}},
+		{"s", 2, []Completion{{Kind: "title", Text: "Sister Outsider", Books: 1}, {Kind: "title", Text: "Stone Butch Blues", Books: 1}}},
+		{"", 8, nil},
+		{"audre", 0, nil},
+		//publishers and subjects aren't offered
+		{"crossing", 8, []Completion{}},
+	}
+	for _, tt := range tests {
+		if got := ix.Complete(tt.prefix, tt.limit); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Complete(%q, %d) = %v, want %v", tt.prefix, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestCompleteLeavesOutAliases(t *testing.T) {
+	ix := testIndex()
+	for _, prefix := range []string{"dead", "deadname", "jane", "jane dead"} {
+		if got := ix.Complete(prefix, 8); len(got) != 0 {
+			t.Errorf("Complete(%q) = %v, want nothing", prefix, got)
+		}
+	}
+	if got := ix.Complete("imogen", 8); !reflect.DeepEqual(got, []Completion{{Kind: "author", Text: "Imogen Binnie", Books: 1}}) {
+		t.Errorf("Complete(imogen) = %v", got)
+	}
+}
+
+func TestCompleteAfterDelete(t *testing.T) {
+	ix := testIndex()
+	ix.Delete(2)
+	want := []Completion{{Kind: "author", Text: "Audre Lorde", Books: 1}}
+	if got := ix.Complete("audre", 8); !reflect.DeepEqual(got, want) {
+		t.Errorf("after deleting Zami, Complete(audre) = %v, want %v", got, want)
+	}
+	ix.Delete(3)
+	if got := ix.Complete("audre", 8); len(got) != 0 {
+		t.Errorf("after deleting both, Complete(audre) = %v, want nothing", got)
+	}
+}
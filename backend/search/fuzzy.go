@@ -0,0 +1,240 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// fields whose words are spelling candidates. subject headings and publishers are full of words
+// nobody types, they'd only make worse suggestions. aliases are left out so a typo is never
+// respelled into someone's former name
+var fuzzyFields = [numFields]bool{Title: true, Author: true, Tag: true}
+
+// maxEdits is how many typos a word of this length is allowed, short words get none
+// or "ace" would match half the catalog
+func maxEdits(word string) int {
+	switch n := utf8.RuneCountInString(word); {
+	case n < 4:
+		return 0
+	case n < 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// trigrams of the word padded with $, "lorde" is $$l $lo lor ord rde de$
+func trigrams(word string) []string {
+	r := []rune("$$" + word + "$")
+	grams := make([]string, 0, len(r)-2)
+	for i := 0; i+3 <= len(r); i++ {
+		grams = append(grams, string(r[i:i+3]))
+	}
+	return unique(grams)
+}
+
+func (ix *Index) addWord(w string) {
+	ix.words[w]++
+	if ix.words[w] > 1 {
+		return
+	}
+	for _, g := range trigrams(w) {
+		if ix.grams[g] == nil {
+			ix.grams[g] = make(map[string]bool)
+		}
+		ix.grams[g][w] = true
+	}
+}
+
+func (ix *Index) removeWord(w string) {
+	if ix.words[w]--; ix.words[w] > 0 {
+		return
+	}
+	delete(ix.words, w)
+	for _, g := range trigrams(w) {
+		delete(ix.grams[g], w)
+		if len(ix.grams[g]) == 0 {
+			delete(ix.grams, g)
+		}
+	}
+}
+
+type neighbor struct {
+	word  string
+	edits int
+}
+
+// neighbors are the indexed words within maxEdits typos of word, word itself left out. trigrams
+// narrow it down first: one edit changes at most 4 of them (a transposition), so anything sharing
+// fewer can't be close enough to be worth measuring
+func (ix *Index) neighbors(word string) []neighbor {
+	limit := maxEdits(word)
+	if limit == 0 {
+		return nil
+	}
+	grams := trigrams(word)
+	shared := make(map[string]int)
+	for _, g := range grams {
+		for w := range ix.grams[g] {
+			shared[w]++
+		}
+	}
+	need := max(len(grams)-4*limit, 1)
+	var result []neighbor
+	for w, n := range shared {
+		if n < need || w == word {
+			continue
+		}
+		if d := editDistance(word, w, limit); d <= limit {
+			result = append(result, neighbor{word: w, edits: d})
+		}
+	}
+	return result
+}
+
+// editDistance is the Damerau-Levenshtein distance (optimal string alignment, so a swapped pair of
+// letters is one typo) between a and b. anything over limit comes back as limit+1
+func editDistance(a, b string, limit int) int {
+	s, t := []rune(a), []rune(b)
+	if abs(len(s)-len(t)) > limit {
+		return limit + 1
+	}
+	prev2 := make([]int, len(t)+1)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			best = min(best, cur[j])
+		}
+		//every later row is at least this far off
+		if best > limit {
+			return limit + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return min(prev[len(t)], limit+1)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Suggest respells q for a "did you mean": words that aren't in the index at all, and words that
+// are but are far rarer than one a single typo away, become the closest indexed word (most common
+// on a tie). only the words and phrases of the query are looked at, its syntax, field names and
+// year: values are left as typed. it returns "" when there's nothing to correct or q doesn't parse
+func (ix *Index) Suggest(q string) string {
+	tokens, err := lex(q)
+	if err != nil {
+		return ""
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	r := []rune(q)
+	var sb strings.Builder
+	changed := false
+	last := 0
+	for _, t := range tokens {
+		if t.kind != tokWord && t.kind != tokPhrase || t.field == "year" {
+			continue
+		}
+		//the value is the end of the token, before the closing quote of a phrase
+		end := t.pos + len([]rune(t.text))
+		if t.kind == tokPhrase {
+			end--
+		}
+		start := end - len([]rune(t.value))
+		respelled, ok := ix.respellText(string(r[start:end]))
+		if !ok {
+			continue
+		}
+		sb.WriteString(string(r[last:start]))
+		sb.WriteString(respelled)
+		last, changed = end, true
+	}
+	if !changed {
+		return ""
+	}
+	sb.WriteString(string(r[last:]))
+	return sb.String()
+}
+
+// respellText respells every word of s, keeping whatever is between them. ok is false when
+// nothing changed
+func (ix *Index) respellText(s string) (string, bool) {
+	r := []rune(s)
+	var sb strings.Builder
+	changed := false
+	for i := 0; i < len(r); {
+		if !isWordRune(r[i]) {
+			sb.WriteRune(r[i])
+			i++
+			continue
+		}
+		start := i
+		for i < len(r) && isWordRune(r[i]) {
+			i++
+		}
+		span := string(r[start:i])
+		if best := ix.respell(span); best != "" {
+			sb.WriteString(best)
+			changed = true
+		} else {
+			sb.WriteString(span)
+		}
+	}
+	return sb.String(), changed
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r) || r == '\'' || r == '’'
+}
+
+// respell is the word Suggest would put in place of span, or "" to leave it
+func (ix *Index) respell(span string) string {
+	words := Tokenize(span)
+	if len(words) != 1 {
+		return ""
+	}
+	w := words[0]
+	t := Term(w)
+	if t == "" {
+		return ""
+	}
+	var best neighbor
+	for _, n := range ix.neighbors(w) {
+		if Term(n.word) == t {
+			continue
+		}
+		if best.word == "" || n.edits < best.edits ||
+			n.edits == best.edits && (ix.words[n.word] > ix.words[best.word] ||
+				ix.words[n.word] == ix.words[best.word] && n.word < best.word) {
+			best = n
+		}
+	}
+	if best.word == "" {
+		return ""
+	}
+	if len(ix.postings[t]) == 0 || best.edits == 1 && ix.words[best.word] >= 10*max(ix.words[w], 1) {
+		return best.word
+	}
+	return ""
+}
@@ -0,0 +1,135 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testIndex() *Index {
+	ix := New()
+	ix.Put(
+		Doc{ID: 1, Title: "Stone Butch Blues", Authors: []string{"Leslie Feinberg"}, Tags: []string{"fiction", "butch"}, Year: 1993},
+		Doc{ID: 2, Title: "Zami: A New Spelling of My Name", Authors: []string{"Audre Lorde"}, Tags: []string{"memoir", "lesbian"}, Year: 1982},
+		Doc{ID: 3, Title: "Sister Outsider", Authors: []string{"Audre Lorde"}, Tags: []string{"essays", "lesbian"}, Publisher: "Crossing Press", Year: 1984},
+		Doc{ID: 4, Title: "Nevada", Authors: []string{"Imogen Binnie"}, AuthorAliases: []string{"Jane Deadname"}, Tags: []string{"fiction", "trans"}, Year: 2013},
+	)
+	return ix
+}
+
+func ids(hits []Hit) []int {
+	var out []int
+	for _, h := range hits {
+		out = append(out, h.ID)
+	}
+	return out
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		limit int
+		want  int
+	}{
+		{"lorde", "lorde", 2, 0},
+		{"lorde", "lord", 2, 1},
+		{"lorde", "loride", 2, 1},
+		{"lorde", "lordi", 2, 1},
+		{"lorde", "lrode", 2, 1}, //a swap is one typo
+		{"feinburg", "feinberg", 2, 1},
+		{"lesley", "leslie", 2, 2},
+		{"binnie", "bunny", 2, 3},
+		{"zami", "outsider", 2, 3}, //over the limit is limit+1
+		{"", "abc", 5, 3},
+		{"mähu", "mahu", 2, 1},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b, tt.limit); got != tt.want {
+			t.Errorf("editDistance(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestMaxEdits(t *testing.T) {
+	for word, want := range map[string]int{"ace": 0, "zami": 1, "lorde": 1, "nevada": 2, "feinberg": 2, "mähu": 1} {
+		if got := maxEdits(word); got != want {
+			t.Errorf("maxEdits(%q) = %d, want %d", word, got, want)
+		}
+	}
+}
+
+func TestFuzzySearch(t *testing.T) {
+	ix := testIndex()
+	tests := []struct {
+		q    string
+		want []int
+	}{
+		{"feinburg", []int{1}},
+		{"lesley feinburg", []int{1}},
+		{"audre lrode", []int{2, 3}},
+		{"outsidr", []int{3}},
+		//too short for a typo
+		{"zam", nil},
+	}
+	for _, tt := range tests {
+		if got := ids(ix.Search(tt.q)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	ix := testIndex()
+	ix.Put(Doc{ID: 5, Title: "1984"})
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"lesley feinburg", "leslie feinberg"},
+		{"Audre Lrode", "Audre lorde"},
+		{"author:lrode -tag:fiction", "author:lorde -tag:fiction"},
+		{"zami OR outsidr", "zami OR outsider"},
+		{`title:"stone bucth"`, `title:"stone butch"`},
+		{"-(lrode OR binnie)", "-(lorde OR binnie)"},
+		{"1985", "1984"},
+		//year: takes numbers, not words, a range mustn't be respelled into a narrower one
+		{"year:1985", ""},
+		{"author:lorde year:1980..1985", ""},
+		{"author:lrode year:1980..1985", "author:lorde year:1980..1985"},
+		{"leslie feinberg", ""},
+		{"xyzzy", ""},
+		{`"lrode`, ""},
+	}
+	for _, tt := range tests {
+		if got := ix.Suggest(tt.q); got != tt.want {
+			t.Errorf("Suggest(%q) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
+
+// an alias finds the book when it's typed in full, but a typo of it is never corrected into it
+func TestAliasesAreNotSpellingWords(t *testing.T) {
+	ix := testIndex()
+	if got := ids(ix.Search("deadname")); !reflect.DeepEqual(got, []int{4}) {
+		t.Errorf("Search(deadname) = %v, want [4]", got)
+	}
+	q, err := Parse("author:deadname")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(ix.Find(q)); !reflect.DeepEqual(got, []int{4}) {
+		t.Errorf("Find(author:deadname) = %v, want [4]", got)
+	}
+	for _, q := range []string{"deadnme", "jane deadnme", "author:deadnam"} {
+		if got := ix.Suggest(q); got != "" {
+			t.Errorf("Suggest(%q) = %q, want nothing", q, got)
+		}
+		if got := ix.Search(q); len(got) != 0 {
+			t.Errorf("Search(%q) = %v, want nothing", q, ids(got))
+		}
+	}
+	for w := range ix.words {
+		if w == "deadname" || w == "jane" {
+			t.Errorf("alias word %q is a spelling candidate", w)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// Package search is the catalog's in-process full text index over books. Titles, author names,
+// tags, subject headings and publishers are analyzed into stemmed terms (see Analyze), every term
+// of a query has to match in at least one field, and hits are ranked with BM25F: term frequencies
+// are weighted per field and length normalized before they're saturated, so a word in the title
+// counts for more than the same word in a long list of tags. A query word the index has never
+// seen matches the title, author and tag words a typo or two away instead (see fuzzy.go).
+package search
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// Field is the part of a book a term was found in
+type Field int
+
+const (
+	Title Field = iota
+	Author
+	Tag
+	Subject
+	Publisher
+	//searchable aliases count like author names, but they're never suggested or respelled to
+	AuthorAlias
+	numFields
+)
+
+var weights = [numFields]float64{Title: 3, Author: 2.5, Tag: 2, Subject: 1.5, Publisher: 1, AuthorAlias: 2.5}
+
+// usual BM25 parameters, k1 is how fast repeats stop counting, b how much field length matters
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// Doc is what the index keeps about a book
+type Doc struct {
+	ID        int
+	Title     string
+	Authors   []string //preferred names
+	Tags      []string
+	Subjects  []string //labels of the book's headings and of everything broader
+	Publisher string
+	//searchable aliases of the authors. they only find the book, a deadname mustn't show up in
+	//autocomplete or as a did-you-mean
+	AuthorAliases []string
+	//not searched, kept for facets
+	AuthorIDs []int
+	Year      int //0 when unknown
+}
+
+func (d Doc) values() [numFields][]string {
+	return [numFields][]string{
+		Title:       {d.Title},
+		Author:      d.Authors,
+		Tag:         d.Tags,
+		Subject:     d.Subjects,
+		Publisher:   {d.Publisher},
+		AuthorAlias: d.AuthorAliases,
+	}
+}
+
+// Hit is a matching book and how well it matched, higher is better
+type Hit struct {
+	ID    int
+	Score float64
+}
+
+type posting struct {
+	tf [numFields]int
+}
+
+type entry struct {
+	doc     Doc
+	lens    [numFields]int
+	terms   []string
+	words   []string //spelling candidates, see fuzzyFields
+	phrases []string //ids of its titles, author names and tags, see complete.go
+}
+
+// Index is safe for concurrent use
+type Index struct {
+	mu        sync.RWMutex
+	docs      map[int]*entry
+	postings  map[string]map[int]*posting
+	totalLen  [numFields]int
+	words     map[string]int             //folded word -> how many books have it
+	grams     map[string]map[string]bool //trigram -> words that have it
+	phrases   map[string]*phrase
+	keys      []completionKey //sorted by key
+	keysDirty bool
+}
+
+func New() *Index {
+	return &Index{
+		docs:     make(map[int]*entry),
+		postings: make(map[string]map[int]*posting),
+		words:    make(map[string]int),
+		grams:    make(map[string]map[string]bool),
+		phrases:  make(map[string]*phrase),
+	}
+}
+
+// Len is the number of books in the index
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.docs)
+}
+
+// Docs returns the indexed docs for ids, in the same order. ids that aren't in the index are skipped
+func (ix *Index) Docs(ids []int) []Doc {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	docs := make([]Doc, 0, len(ids))
+	for _, id := range ids {
+		if e, ok := ix.docs[id]; ok {
+			docs = append(docs, e.doc)
+		}
+	}
+	return docs
+}
+
+// Replace swaps the whole index for docs in one go, searches never see it half built
+func (ix *Index) Replace(docs []Doc) {
+	fresh := New()
+	for _, d := range docs {
+		fresh.add(d)
+	}
+	fresh.sortKeys()
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.docs, ix.postings, ix.totalLen = fresh.docs, fresh.postings, fresh.totalLen
+	ix.words, ix.grams = fresh.words, fresh.grams
+	ix.phrases, ix.keys = fresh.phrases, fresh.keys
+}
+
+// Put adds docs, replacing any with the same ID
+func (ix *Index) Put(docs ...Doc) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for _, d := range docs {
+		ix.remove(d.ID)
+		ix.add(d)
+	}
+	ix.sortKeys()
+}
+
+func (ix *Index) Delete(ids ...int) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for _, id := range ids {
+		ix.remove(id)
+	}
+	ix.sortKeys()
+}
+
+func (ix *Index) add(d Doc) {
+	e := &entry{doc: d}
+	for f, values := range d.values() {
+		for _, v := range values {
+			for _, t := range Analyze(v) {
+				list := ix.postings[t]
+				if list == nil {
+					list = make(map[int]*posting)
+					ix.postings[t] = list
+				}
+				p := list[d.ID]
+				if p == nil {
+					p = &posting{}
+					list[d.ID] = p
+					e.terms = append(e.terms, t)
+				}
+				p.tf[f]++
+				e.lens[f]++
+			}
+		}
+	}
+	for f, values := range d.values() {
+		if !fuzzyFields[f] {
+			continue
+		}
+		for _, v := range values {
+			for _, w := range Tokenize(v) {
+				if Term(w) != "" {
+					e.words = append(e.words, w)
+				}
+			}
+		}
+	}
+	e.words = unique(e.words)
+	for _, w := range e.words {
+		ix.addWord(w)
+	}
+	ix.addPhrases(e)
+	for f := range e.lens {
+		ix.totalLen[f] += e.lens[f]
+	}
+	ix.docs[d.ID] = e
+}
+
+func (ix *Index) remove(id int) {
+	e, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	for _, t := range e.terms {
+		delete(ix.postings[t], id)
+		if len(ix.postings[t]) == 0 {
+			delete(ix.postings, t)
+		}
+	}
+	for _, w := range e.words {
+		ix.removeWord(w)
+	}
+	ix.removePhrases(e)
+	for f := range e.lens {
+		ix.totalLen[f] -= e.lens[f]
+	}
+	delete(ix.docs, id)
+}
+
+// alternative is one way to match a query word: the word's own term, or the term of a
+// misspelling of it. weight scales down the score of fuzzy ones
+type alternative struct {
+	list   map[int]*posting
+	weight float64
+}
+
+// Search returns every book matching all the words in q, best first. equal scores go by ID
+// so paging through the hits is stable. a word that isn't in the index matches the words a typo or
+// two away instead, each typo halving its score. q is taken as plain words, Parse reads the syntax
+func (ix *Index) Search(q string) []Hit {
+	var words andNode
+	for _, w := range Tokenize(q) {
+		words = append(words, wordNode{word: w, fields: allFields})
+	}
+	return ix.Find(&Query{root: words})
+}
+
+// alternatives are the posting lists query word w (with term t) matches: its own if the index has
+// it, otherwise those of its neighbors
+func (ix *Index) alternatives(w, t string) []alternative {
+	if list := ix.postings[t]; len(list) > 0 {
+		return []alternative{{list: list, weight: 1}}
+	}
+	weights := make(map[string]float64)
+	for _, n := range ix.neighbors(w) {
+		weights[Term(n.word)] = max(weights[Term(n.word)], math.Pow(0.5, float64(n.edits)))
+	}
+	var alts []alternative
+	for term, weight := range weights {
+		if list := ix.postings[term]; len(list) > 0 {
+			alts = append(alts, alternative{list: list, weight: weight})
+		}
+	}
+	return alts
+}
+
+// score is one term's BM25F contribution to one book counting only the given fields, df is how
+// many books have the term
+func (ix *Index) score(id int, p *posting, df int, fields [numFields]bool) float64 {
+	n := float64(len(ix.docs))
+	e := ix.docs[id]
+	tf := 0.0
+	for f := Field(0); f < numFields; f++ {
+		if p.tf[f] == 0 || !fields[f] {
+			continue
+		}
+		avg := float64(ix.totalLen[f]) / n
+		if avg == 0 {
+			avg = 1
+		}
+		tf += weights[f] * float64(p.tf[f]) / (1 - b + b*float64(e.lens[f])/avg)
+	}
+	idf := math.Log(1 + (n-float64(df)+0.5)/(float64(df)+0.5))
+	return idf * tf * (k1 + 1) / (tf + k1)
+}
+
+func sortHits(hits []Hit) {
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+}
+
+func unique(terms []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, t := range terms {
+		if !seen[t] {
+			seen[t] = true
+			result = append(result, t)
+		}
+	}
+	return result
+}
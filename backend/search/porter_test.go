@@ -0,0 +1,88 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+// examples from Porter's paper, run through every step, plus a few from the catalog
+func TestStem(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		//step 1a
+		{"caresses", "caress"}, {"ponies", "poni"}, {"ties", "ti"}, {"caress", "caress"}, {"cats", "cat"},
+		//step 1b
+		{"feed", "feed"}, {"agreed", "agre"}, {"plastered", "plaster"}, {"bled", "bled"},
+		{"motoring", "motor"}, {"sing", "sing"}, {"conflated", "conflat"}, {"troubled", "troubl"},
+		{"sized", "size"}, {"hopping", "hop"}, {"tanned", "tan"}, {"falling", "fall"},
+		{"hissing", "hiss"}, {"fizzed", "fizz"}, {"failing", "fail"}, {"filing", "file"},
+		//step 1c
+		{"happy", "happi"}, {"sky", "sky"},
+		//step 2
+		{"relational", "relat"}, {"conditional", "condit"}, {"rational", "ration"}, {"valenci", "valenc"},
+		{"hesitanci", "hesit"}, {"digitizer", "digit"}, {"conformabli", "conform"}, {"radicalli", "radic"},
+		{"differentli", "differ"}, {"vileli", "vile"}, {"analogousli", "analog"}, {"vietnamization", "vietnam"},
+		{"predication", "predic"}, {"operator", "oper"}, {"feudalism", "feudal"}, {"decisiveness", "decis"},
+		{"hopefulness", "hope"}, {"callousness", "callous"}, {"formaliti", "formal"}, {"sensitiviti", "sensit"},
+		{"sensibiliti", "sensibl"},
+		//step 3
+		{"triplicate", "triplic"}, {"formative", "form"}, {"formalize", "formal"}, {"electriciti", "electr"},
+		{"electrical", "electr"}, {"hopeful", "hope"}, {"goodness", "good"},
+		//step 4
+		{"revival", "reviv"}, {"allowance", "allow"}, {"inference", "infer"}, {"airliner", "airlin"},
+		{"gyroscopic", "gyroscop"}, {"adjustable", "adjust"}, {"defensible", "defens"}, {"irritant", "irrit"},
+		{"replacement", "replac"}, {"adjustment", "adjust"}, {"dependent", "depend"}, {"adoption", "adopt"},
+		{"homologou", "homolog"}, {"communism", "commun"}, {"activate", "activ"}, {"angulariti", "angular"},
+		{"homologous", "homolog"}, {"effective", "effect"}, {"bowdlerize", "bowdler"},
+		//step 5
+		{"probate", "probat"}, {"rate", "rate"}, {"cease", "ceas"}, {"controll", "control"}, {"roll", "roll"},
+		//all of them at once
+		{"generalizations", "gener"}, {"oscillators", "oscil"},
+		{"lesbians", "lesbian"}, {"queer", "queer"}, {"transgender", "transgend"}, {"butch", "butch"},
+		//too short, or not lowercase ascii, is left alone
+		{"is", "is"}, {"as", "as"}, {"Zami", "Zami"}, {"café", "café"}, {"2spirit", "2spirit"}, {"", ""},
+	}
+	for _, tt := range tests {
+		if got := Stem(tt.word); got != tt.want {
+			t.Errorf("Stem(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"Zami’s", "zamis"},
+		{"Zami's", "zamis"},
+		{"Café Mähu", "cafe mahu"},
+		{"ＳＴＯＮＥ", "stone"},
+		{"Two-Spirit", "two-spirit"},
+	}
+	for _, tt := range tests {
+		if got := Fold(tt.s); got != tt.want {
+			t.Errorf("Fold(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"The Stone Butch Blues", []string{"stone", "butch", "blue"}},
+		{"Zami: A New Spelling of My Name", []string{"zami", "new", "spell", "my", "name"}},
+		{"Two-Spirit people", []string{"two", "spirit", "peopl"}},
+		{"Lorde’s poems, 1978", []string{"lord", "poem", "1978"}},
+		{"the and of", nil},
+	}
+	for _, tt := range tests {
+		if got := Analyze(tt.s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Analyze(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,572 @@
+package search
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// query syntax, for staff who want more than a list of words:
+//
+//	author:lorde tag:poetry year:1980..1990 -tag:fiction "sister outsider"
+//
+// words are AND'd, OR (upper case) between two of them matches either, -x leaves out books that
+// match x and (...) groups. a field: prefix (title, author, tag, subject, publisher) matches only in
+// that field, "..." matches the words next to each other in that order, and year: takes 1985,
+// 1980..1990, 1980.. or ..1990. a bare word the index doesn't know still gets typo matching
+
+// fieldNames are the prefixes a word or phrase can have, and the fields each one looks in.
+// author: takes searchable aliases too, like a bare word does
+var fieldNames = map[string][numFields]bool{
+	"title":     only(Title),
+	"author":    {Author: true, AuthorAlias: true},
+	"tag":       only(Tag),
+	"subject":   only(Subject),
+	"publisher": only(Publisher),
+}
+
+// SyntaxError is a query that doesn't parse, with the token it tripped on
+type SyntaxError struct {
+	Offset  int    //in characters from the start of the query
+	Token   string //"" at the end of the query
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("%s at the end of the query", e.Message)
+	}
+	return fmt.Sprintf("%s at %q (offset %d)", e.Message, e.Token, e.Offset)
+}
+
+// Query is a parsed query, see Parse
+type Query struct {
+	root node
+}
+
+type node interface {
+	eval(ix *Index) matches
+}
+
+// matches is what a node matched, book -> score. all means every book, for nodes with nothing to
+// look for like a lone "the", so ANDing them in changes nothing
+type matches struct {
+	all    bool
+	scores map[int]float64
+}
+
+type (
+	andNode []node
+	orNode  []node
+	notNode struct{ n node }
+	//one word, fields is where it has to be
+	wordNode struct {
+		word   string
+		fields [numFields]bool
+	}
+	//words next to each other in one value of one of fields
+	phraseNode struct {
+		words  []string
+		fields [numFields]bool
+	}
+	yearNode struct{ from, to int }
+)
+
+var allFields = [numFields]bool{true, true, true, true, true, true}
+
+// Parse reads a query in the syntax above
+func Parse(s string) (*Query, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, end: len([]rune(s))}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.peek(); ok {
+		return nil, &SyntaxError{Offset: t.pos, Token: t.text, Message: "unmatched )"}
+	}
+	return &Query{root: root}, nil
+}
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokPhrase
+	tokOpen
+	tokClose
+	tokOr
+	tokNot
+)
+
+type token struct {
+	kind  tokenKind
+	text  string //as typed, for errors
+	field string //before the colon, "" for none
+	value string //after it, or the whole word. the inside of the quotes for a phrase
+	pos   int
+}
+
+func lex(s string) ([]token, error) {
+	r := []rune(s)
+	var tokens []token
+	for i := 0; i < len(r); {
+		switch c := r[i]; {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokOpen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokClose, text: ")", pos: i})
+			i++
+		case c == '-' && i+1 < len(r) && !unicode.IsSpace(r[i+1]):
+			if r[i+1] == ')' {
+				return nil, &SyntaxError{Offset: i, Token: "-", Message: "nothing after - to leave out"}
+			}
+			tokens = append(tokens, token{kind: tokNot, text: "-", pos: i})
+			i++
+		case c == '"':
+			phrase, next, err := lexPhrase(r, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokPhrase, text: string(r[i:next]), value: phrase, pos: i})
+			i = next
+		default:
+			start := i
+			for i < len(r) && !unicode.IsSpace(r[i]) && r[i] != '(' && r[i] != ')' && r[i] != '"' && r[i] != ':' {
+				i++
+			}
+			word := string(r[start:i])
+			if i < len(r) && r[i] == ':' {
+				t, next, err := lexField(r, start, i)
+				if err != nil {
+					return nil, err
+				}
+				if next > 0 {
+					tokens = append(tokens, t)
+					i = next
+					continue
+				}
+				//a colon that isn't a field, like "Zami: A New Spelling"
+				for i < len(r) && !unicode.IsSpace(r[i]) && r[i] != '(' && r[i] != ')' && r[i] != '"' {
+					i++
+				}
+				word = string(r[start:i])
+			}
+			t := token{kind: tokWord, text: word, value: word, pos: start}
+			if word == "OR" {
+				t.kind = tokOr
+			}
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+// lexPhrase reads the quoted phrase starting at r[i], returning its inside and where it ends
+func lexPhrase(r []rune, i int) (string, int, error) {
+	for j := i + 1; j < len(r); j++ {
+		if r[j] == '"' {
+			return string(r[i+1 : j]), j + 1, nil
+		}
+	}
+	return "", 0, &SyntaxError{Offset: i, Token: string(r[i:]), Message: "unclosed quote"}
+}
+
+// lexField reads name:value where r[colon] is the colon. next is 0 when name isn't a field and the
+// colon is just part of a word
+func lexField(r []rune, start, colon int) (token, int, error) {
+	name := strings.ToLower(string(r[start:colon]))
+	i := colon + 1
+	if i < len(r) && r[i] == '"' {
+		phrase, next, err := lexPhrase(r, i)
+		if err != nil {
+			return token{}, 0, err
+		}
+		t := token{kind: tokPhrase, text: string(r[start:next]), field: name, value: phrase, pos: start}
+		return t, next, checkField(t)
+	}
+	for i < len(r) && !unicode.IsSpace(r[i]) && r[i] != '(' && r[i] != ')' && r[i] != '"' {
+		i++
+	}
+	t := token{kind: tokWord, text: string(r[start:i]), field: name, value: string(r[colon+1 : i]), pos: start}
+	_, known := fieldNames[name]
+	if !known && name != "year" {
+		if t.value == "" || !isIdent(name) {
+			return token{}, 0, nil
+		}
+	}
+	return t, i, checkField(t)
+}
+
+func checkField(t token) error {
+	if _, ok := fieldNames[t.field]; !ok && t.field != "year" {
+		return &SyntaxError{Offset: t.pos, Token: t.text, Message: fmt.Sprintf("unknown field %q, use title, author, tag, subject, publisher or year", t.field)}
+	}
+	if strings.TrimSpace(t.value) == "" {
+		return &SyntaxError{Offset: t.pos, Token: t.text, Message: t.field + ": needs a value right after the colon"}
+	}
+	if t.field == "year" && t.kind == tokPhrase {
+		return &SyntaxError{Offset: t.pos, Token: t.text, Message: "year: takes a year or a range like 1980..1990"}
+	}
+	return nil
+}
+
+func isIdent(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+type parser struct {
+	tokens []token
+	i      int
+	end    int //length of the query, where errors about running out point
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.i < len(p.tokens) {
+		return p.tokens[p.i], true
+	}
+	return token{}, false
+}
+
+// or := and ("OR" and)*
+func (p *parser) or() (node, error) {
+	first, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	alts := orNode{first}
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind != tokOr {
+			break
+		}
+		p.i++
+		next, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		alts = append(alts, next)
+	}
+	if len(alts) == 1 {
+		return first, nil
+	}
+	return alts, nil
+}
+
+// and := unary+, stopping at ")" or OR
+func (p *parser) and() (node, error) {
+	var all andNode
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind == tokClose || t.kind == tokOr {
+			break
+		}
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, n)
+	}
+	if len(all) == 0 {
+		t, ok := p.peek()
+		switch {
+		case ok && t.kind == tokOr:
+			return nil, &SyntaxError{Offset: t.pos, Token: t.text, Message: "OR needs something on both sides"}
+		case p.i > 0 && p.tokens[p.i-1].kind == tokOr:
+			prev := p.tokens[p.i-1]
+			return nil, &SyntaxError{Offset: prev.pos, Token: prev.text, Message: "OR needs something on both sides"}
+		case ok:
+			return nil, &SyntaxError{Offset: t.pos, Token: t.text, Message: "unmatched )"}
+		case p.i > 0 && p.tokens[p.i-1].kind == tokOpen:
+			prev := p.tokens[p.i-1]
+			return nil, &SyntaxError{Offset: prev.pos, Token: prev.text, Message: "missing ) for this ("}
+		}
+		return nil, &SyntaxError{Offset: p.end, Message: "empty query"}
+	}
+	if len(all) == 1 {
+		return all[0], nil
+	}
+	return all, nil
+}
+
+// unary := "-" unary | "(" or ")" | word | phrase
+func (p *parser) unary() (node, error) {
+	t := p.tokens[p.i]
+	p.i++
+	switch t.kind {
+	case tokNot:
+		next, ok := p.peek()
+		if !ok || next.kind == tokOr || next.kind == tokClose {
+			return nil, &SyntaxError{Offset: t.pos, Token: t.text, Message: "nothing after - to leave out"}
+		}
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	case tokOpen:
+		if next, ok := p.peek(); ok && next.kind == tokClose {
+			return nil, &SyntaxError{Offset: t.pos, Token: "()", Message: "empty parentheses"}
+		}
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if next, ok := p.peek(); !ok || next.kind != tokClose {
+			return nil, &SyntaxError{Offset: t.pos, Token: t.text, Message: "missing ) for this ("}
+		}
+		p.i++
+		return n, nil
+	case tokPhrase:
+		words := Tokenize(t.value)
+		fields := allFields
+		if t.field != "" {
+			fields = fieldNames[t.field]
+		}
+		switch len(words) {
+		case 0:
+			return andNode{}, nil
+		case 1:
+			//still in quotes, so no typo matching
+			return exactNode{wordNode{word: words[0], fields: fields}}, nil
+		}
+		return phraseNode{words: words, fields: fields}, nil
+	}
+	//a word
+	if t.field == "year" {
+		return parseYears(t)
+	}
+	fields := allFields
+	if t.field != "" {
+		fields = fieldNames[t.field]
+	}
+	//punctuation inside a word splits it, "self-portrait" is self AND portrait
+	words := Tokenize(t.value)
+	if len(words) == 1 {
+		return wordNode{word: words[0], fields: fields}, nil
+	}
+	var all andNode
+	for _, w := range words {
+		all = append(all, wordNode{word: w, fields: fields})
+	}
+	return all, nil
+}
+
+func only(f Field) [numFields]bool {
+	var fields [numFields]bool
+	fields[f] = true
+	return fields
+}
+
+// parseYears reads 1985, 1980..1990, 1980.. or ..1990, both ends included
+func parseYears(t token) (node, error) {
+	bad := &SyntaxError{Offset: t.pos, Token: t.text, Message: "year: takes a year or a range like 1980..1990"}
+	from, to, isRange := strings.Cut(t.value, "..")
+	if !isRange {
+		to = from
+	}
+	n := yearNode{from: 0, to: 1 << 30}
+	if from != "" {
+		year, err := strconv.Atoi(from)
+		if err != nil || year < 0 {
+			return nil, bad
+		}
+		n.from = year
+	}
+	if to != "" {
+		year, err := strconv.Atoi(to)
+		if err != nil || year < 0 {
+			return nil, bad
+		}
+		n.to = year
+	}
+	if from == "" && to == "" {
+		return nil, bad
+	}
+	if n.from > n.to {
+		return nil, &SyntaxError{Offset: t.pos, Token: t.text, Message: "year range goes backwards"}
+	}
+	return n, nil
+}
+
+// Find returns the books matching q, best first. equal scores go by ID
+func (ix *Index) Find(q *Query) []Hit {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	m := q.root.eval(ix)
+	if m.all {
+		return nil
+	}
+	hits := make([]Hit, 0, len(m.scores))
+	for id, score := range m.scores {
+		hits = append(hits, Hit{ID: id, Score: score})
+	}
+	sortHits(hits)
+	return hits
+}
+
+func (ix *Index) everything() map[int]float64 {
+	scores := make(map[int]float64, len(ix.docs))
+	for id := range ix.docs {
+		scores[id] = 0
+	}
+	return scores
+}
+
+func (n andNode) eval(ix *Index) matches {
+	result := matches{all: true}
+	//positive parts first, so a leading -x doesn't have to start from every book
+	var negated []notNode
+	for _, part := range n {
+		if not, ok := part.(notNode); ok {
+			negated = append(negated, not)
+			continue
+		}
+		m := part.eval(ix)
+		switch {
+		case m.all:
+		case result.all:
+			result = m
+		default:
+			for id, score := range result.scores {
+				if other, ok := m.scores[id]; ok {
+					result.scores[id] = score + other
+				} else {
+					delete(result.scores, id)
+				}
+			}
+		}
+	}
+	for _, not := range negated {
+		m := not.n.eval(ix)
+		if m.all {
+			return matches{scores: map[int]float64{}}
+		}
+		if result.all {
+			result = matches{scores: ix.everything()}
+		}
+		for id := range m.scores {
+			delete(result.scores, id)
+		}
+	}
+	return result
+}
+
+// an OR with a lone stopword in it, "lesbian OR the", is read as if the stopword weren't there
+func (n orNode) eval(ix *Index) matches {
+	result := matches{all: true, scores: make(map[int]float64)}
+	for _, alt := range n {
+		m := alt.eval(ix)
+		if m.all {
+			continue
+		}
+		result.all = false
+		for id, score := range m.scores {
+			result.scores[id] += score
+		}
+	}
+	return result
+}
+
+func (n notNode) eval(ix *Index) matches {
+	return andNode{n}.eval(ix)
+}
+
+func (n wordNode) eval(ix *Index) matches {
+	t := Term(n.word)
+	if t == "" {
+		return matches{all: true}
+	}
+	scores := make(map[int]float64)
+	for _, a := range ix.alternatives(n.word, t) {
+		for id, p := range a.list {
+			if score := a.weight * ix.score(id, p, len(a.list), n.fields); score > 0 {
+				scores[id] = max(scores[id], score)
+			}
+		}
+	}
+	return matches{scores: scores}
+}
+
+func (n phraseNode) eval(ix *Index) matches {
+	//books with every word, exact spelling, then the ones where they're in order
+	var words andNode
+	for _, w := range n.words {
+		words = append(words, exactNode{wordNode{word: w, fields: n.fields}})
+	}
+	m := words.eval(ix)
+	if m.all {
+		m.scores = ix.everything()
+	}
+	want := make([]string, len(n.words))
+	for i, w := range n.words {
+		want[i] = Stem(w)
+	}
+	for id := range m.scores {
+		if !ix.hasPhrase(id, want, n.fields) {
+			delete(m.scores, id)
+		}
+	}
+	return matches{scores: m.scores}
+}
+
+// exactNode is a word without typo matching, a phrase in quotes means exactly those words
+type exactNode struct{ wordNode }
+
+func (n exactNode) eval(ix *Index) matches {
+	t := Term(n.word)
+	if t == "" {
+		return matches{all: true}
+	}
+	scores := make(map[int]float64)
+	list := ix.postings[t]
+	for id, p := range list {
+		if score := ix.score(id, p, len(list), n.fields); score > 0 {
+			scores[id] = score
+		}
+	}
+	return matches{scores: scores}
+}
+
+// hasPhrase reports whether one of the book's values in fields has the stems in want in a row
+func (ix *Index) hasPhrase(id int, want []string, fields [numFields]bool) bool {
+	for f, values := range ix.docs[id].doc.values() {
+		if !fields[f] {
+			continue
+		}
+		for _, v := range values {
+			words := Tokenize(v)
+			for start := 0; start+len(want) <= len(words); start++ {
+				i := 0
+				for ; i < len(want) && Stem(words[start+i]) == want[i]; i++ {
+				}
+				if i == len(want) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (n yearNode) eval(ix *Index) matches {
+	scores := make(map[int]float64)
+	for id, e := range ix.docs {
+		if y := e.doc.Year; y > 0 && y >= n.from && y <= n.to {
+			scores[id] = 0
+		}
+	}
+	return matches{scores: scores}
+}
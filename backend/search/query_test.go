@@ -0,0 +1,174 @@
+package search
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func word(w string, fields [numFields]bool) wordNode {
+	return wordNode{word: w, fields: fields}
+}
+
+func TestParse(t *testing.T) {
+	authors := fieldNames["author"]
+	tests := []struct {
+		q    string
+		want node
+	}{
+		{"lorde", word("lorde", allFields)},
+		{"audre lorde", andNode{word("audre", allFields), word("lorde", allFields)}},
+		{"zami OR outsider OR nevada", orNode{word("zami", allFields), word("outsider", allFields), word("nevada", allFields)}},
+		//OR binds looser than AND
+		{"audre lorde OR binnie", orNode{andNode{word("audre", allFields), word("lorde", allFields)}, word("binnie", allFields)}},
+		{"(lorde OR binnie) fiction", andNode{orNode{word("lorde", allFields), word("binnie", allFields)}, word("fiction", allFields)}},
+		{"((lorde))", word("lorde", allFields)},
+		{"lorde or binnie", andNode{word("lorde", allFields), word("or", allFields), word("binnie", allFields)}},
+		{"-tag:fiction", notNode{word("fiction", only(Tag))}},
+		{"lorde -(essays OR poetry)", andNode{word("lorde", allFields), notNode{orNode{word("essays", allFields), word("poetry", allFields)}}}},
+		//a dash on its own is punctuation
+		{"- lorde", andNode{andNode(nil), word("lorde", allFields)}},
+		{"author:lorde", word("lorde", authors)},
+		{"AUTHOR:Lorde", word("lorde", authors)},
+		{"author:lorde tag:poetry", andNode{word("lorde", authors), word("poetry", only(Tag))}},
+		{"subject:Butches publisher:crossing", andNode{word("butches", only(Subject)), word("crossing", only(Publisher))}},
+		{`"sister outsider"`, phraseNode{words: []string{"sister", "outsider"}, fields: allFields}},
+		{`title:"stone butch"`, phraseNode{words: []string{"stone", "butch"}, fields: only(Title)}},
+		{`"zami"`, exactNode{word("zami", allFields)}},
+		{`""`, andNode{}},
+		//punctuation splits a word, and a colon that isn't a field is part of it
+		{"self-portrait", andNode{word("self", allFields), word("portrait", allFields)}},
+		{"Zami: a new", andNode{word("zami", allFields), word("a", allFields), word("new", allFields)}},
+		{"10:30", andNode{word("10", allFields), word("30", allFields)}},
+		{"year:1985", yearNode{1985, 1985}},
+		{"year:1980..1990", yearNode{1980, 1990}},
+		{"year:1980..", yearNode{1980, 1 << 30}},
+		{"year:..1990", yearNode{0, 1990}},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.q)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.q, err)
+			continue
+		}
+		if !reflect.DeepEqual(q.root, tt.want) {
+			t.Errorf("Parse(%q) = %#v, want %#v", tt.q, q.root, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		q       string
+		offset  int
+		token   string
+		message string
+	}{
+		{"", 0, "", "empty query"},
+		{"   ", 3, "", "empty query"},
+		{"zami)", 4, ")", "unmatched )"},
+		{")", 0, ")", "unmatched )"},
+		{"(zami", 0, "(", "missing ) for this ("},
+		{"(", 0, "(", "missing ) for this ("},
+		{"lorde ()", 6, "()", "empty parentheses"},
+		{"zami OR", 5, "OR", "OR needs something on both sides"},
+		{"OR zami", 0, "OR", "OR needs something on both sides"},
+		{"zami OR OR outsider", 8, "OR", "OR needs something on both sides"},
+		{"a OR (b OR )", 8, "OR", "OR needs something on both sides"},
+		{"(lorde -)", 7, "-", "nothing after - to leave out"},
+		{"lorde -OR zami", 6, "-", "nothing after - to leave out"},
+		{`"sister outsider`, 0, `"sister outsider`, "unclosed quote"},
+		{`title:"stone`, 6, `"stone`, "unclosed quote"},
+		{"foo:bar", 0, "foo:bar", `unknown field "foo", use title, author, tag, subject, publisher or year`},
+		{"author:", 0, "author:", "author: needs a value right after the colon"},
+		{`tag:"  "`, 0, `tag:"  "`, "tag: needs a value right after the colon"},
+		{`year:"1985"`, 0, `year:"1985"`, "year: takes a year or a range like 1980..1990"},
+		{"year:eighties", 0, "year:eighties", "year: takes a year or a range like 1980..1990"},
+		{"year:..", 0, "year:..", "year: takes a year or a range like 1980..1990"},
+		{"year:1990..1980", 0, "year:1990..1980", "year range goes backwards"},
+		//offsets count characters, not bytes
+		{"Mähu zami)", 9, ")", "unmatched )"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.q)
+		var se *SyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("Parse(%q) = %v, want a SyntaxError", tt.q, err)
+			continue
+		}
+		if se.Offset != tt.offset || se.Token != tt.token || se.Message != tt.message {
+			t.Errorf("Parse(%q) = %+v, want {Offset:%d Token:%s Message:%s}", tt.q, *se, tt.offset, tt.token, tt.message)
+		}
+	}
+}
+
+func TestSyntaxErrorMessage(t *testing.T) {
+	for err, want := range map[*SyntaxError]string{
+		{Offset: 4, Token: ")", Message: "unmatched )"}: `unmatched ) at ")" (offset 4)`,
+		{Offset: 7, Message: "empty query"}:             "empty query at the end of the query",
+	} {
+		if got := err.Error(); got != want {
+			t.Errorf("Error() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	ix := testIndex()
+	tests := []struct {
+		q    string
+		want []int
+	}{
+		{"lorde", []int{2, 3}},
+		{"author:lorde", []int{2, 3}},
+		{"title:lorde", nil},
+		{"tag:fiction", []int{1, 4}},
+		{"fiction -tag:trans", []int{1}},
+		{"-tag:fiction", []int{2, 3}},
+		{"-lesbian -fiction", nil},
+		{"zami OR nevada", []int{2, 4}},
+		{"(zami OR nevada) fiction", []int{4}},
+		{`"stone butch"`, []int{1}},
+		{`"butch stone"`, nil},
+		{`title:"butch blues"`, []int{1}},
+		{`"audre lorde" essays`, []int{3}},
+		//no typo matching inside quotes
+		{`"feinburg"`, nil},
+		{"feinburg", []int{1}},
+		{"publisher:crossing", []int{3}},
+		{"year:1980..1990", []int{2, 3}},
+		{"year:..1990", []int{2, 3}},
+		{"year:2000..", []int{4}},
+		{"year:1993 butch", []int{1}},
+		{"the", nil},
+		{"the lorde", []int{2, 3}},
+		{"lesbian OR the", []int{2, 3}},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.q)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.q, err)
+			continue
+		}
+		//the match set is what's under test here, TestFindRanking covers the order
+		got := ids(ix.Find(q))
+		sort.Ints(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Find(%q) = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+}
+
+// a title match outranks the same word anywhere else
+func TestFindRanking(t *testing.T) {
+	ix := testIndex()
+	ix.Put(Doc{ID: 5, Title: "Sister Love", Tags: []string{"butch"}})
+	q, err := Parse("butch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(ix.Find(q)); !reflect.DeepEqual(got, []int{1, 5}) {
+		t.Errorf("Find(butch) = %v, want [1 5]", got)
+	}
+}